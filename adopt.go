@@ -0,0 +1,136 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrAdoptMismatch is returned when [Schema.Adopt] is set and a database
+// without a version already has tables that do not match the schema.
+var ErrAdoptMismatch = errors.New("existing tables do not match the schema")
+
+// adopt fast-forwards a database that has tables but no version to target, if
+// its live schema matches the one that migrating a fresh database to target
+// produces. It does nothing if the database has a version or has no tables.
+// The PreStatements must already have been executed on conn.
+func (s *Schema) adopt(ctx context.Context, db *sql.DB, conn *sql.Conn, target int) error {
+	target = min(target, s.VersionCount())
+
+	return s.retryTx(ctx, conn, nil, func(tx DBTX) error {
+		v, _, err := s.startVersion(ctx, tx)
+		if err != nil || v != 0 {
+			return err
+		}
+
+		hasTables, err := s.hasUserTables(ctx, tx)
+		if err != nil || !hasTables {
+			return err
+		}
+
+		want, err := s.referenceSchemaHash(ctx, db.Driver(), target)
+		if err != nil {
+			return err
+		}
+
+		got, err := s.liveSchemaHash(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if got != want {
+			return fmt.Errorf("cannot adopt database at version %d: %w", target, ErrAdoptMismatch)
+		}
+
+		numbers, err := s.numbers()
+		if err != nil {
+			return err
+		}
+
+		s.logf("adopting existing database at version %d", target)
+		return s.setVersion(ctx, tx, target, numbers)
+	})
+}
+
+// hasUserTables returns true if the database has any tables other than the
+// internal ones of SQLite and lazymigrate.
+func (s *Schema) hasUserTables(ctx context.Context, q DBTX) (bool, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return false, fmt.Errorf("cannot list tables: %w", err)
+	}
+	defer rows.Close()
+
+	ignored := s.ownTables()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, fmt.Errorf("cannot scan table name: %w", err)
+		}
+		if !containsFold(ignored, name) {
+			return true, nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("cannot list tables: %w", err)
+	}
+
+	return false, nil
+}
+
+// referenceSchemaHash migrates a fresh in-memory database, opened with the
+// same driver, to target and returns the hash of its live schema.
+func (s *Schema) referenceSchemaHash(ctx context.Context, d driver.Driver, target int) (string, error) {
+	if err := s.load(); err != nil {
+		return "", err
+	}
+
+	ref := sql.OpenDB(dsnConnector{driver: d, dsn: ":memory:"})
+	defer ref.Close()
+
+	// Every connection to ":memory:" has its own database.
+	ref.SetMaxOpenConns(1)
+
+	// Only the versions themselves should run against the reference
+	// database, so leave out the hooks, the PreStatements and other side
+	// effects.
+	cp := NewSchema(s.schema, WithStore(s.store))
+	cp.magic = s.magic
+	cp.altMagics = s.altMagics
+	cp.match = s.match
+	cp.Rewrite = s.Rewrite
+	cp.WrapEach = s.WrapEach
+	cp.Guard = s.Guard
+	cp.NumberedVersions = s.NumberedVersions
+
+	if _, err := cp.migrateDB(ctx, ref, target, nil); err != nil {
+		return "", fmt.Errorf("cannot migrate reference database: %w", err)
+	}
+
+	conn, err := ref.Conn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return cp.liveSchemaHash(ctx, conn)
+}
+
+// dsnConnector is a [driver.Connector] that opens connections to a fixed data
+// source name.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,17 @@
+package lazymigrate
+
+// AheadPolicy decides what migrating does when the database is ahead of the
+// schema, such as when an older version of the program runs against a
+// database that a newer version has already migrated.
+type AheadPolicy int
+
+const (
+	// OnAheadIgnore leaves the database as-is and reports no error. This is
+	// the default.
+	OnAheadIgnore AheadPolicy = iota
+	// OnAheadError fails with an error wrapping [ErrDatabaseAhead].
+	OnAheadError
+	// OnAheadWarn is like OnAheadIgnore, but it also logs a warning using the
+	// Schema's Logger.
+	OnAheadWarn
+)
@@ -0,0 +1,44 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// MigrateAll migrates each of the given databases with [Schema.Migrate],
+// running at most limit migrations at once. If limit is 0 or less, all
+// databases are migrated at once. A failure does not stop the other
+// migrations. The returned map holds the error of each database that failed,
+// keyed by its index in dbs, and is empty if all of them succeeded.
+func (s *Schema) MigrateAll(ctx context.Context, dbs []*sql.DB, limit int) map[int]error {
+	if limit <= 0 || limit > len(dbs) {
+		limit = len(dbs)
+	}
+
+	var mu sync.Mutex
+	errs := make(map[int]error)
+
+	var wg sync.WaitGroup
+	sema := make(chan struct{}, limit)
+
+	for i, db := range dbs {
+		i, db := i, db
+
+		wg.Add(1)
+		sema <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+
+			if err := s.Migrate(ctx, db); err != nil {
+				mu.Lock()
+				errs[i] = err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return errs
+}
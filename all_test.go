@@ -0,0 +1,67 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMigrateAll(t *testing.T) {
+	const n = 6
+	const limit = 2
+
+	fakes := make([]*fakeDB, n)
+	dbs := make([]*sql.DB, n)
+	for i := range dbs {
+		fakes[i], dbs[i] = newFakeDB(t, 0)
+	}
+
+	failure := errors.New("disk is full")
+	fakes[3].errs["CREATE TABLE a (id INTEGER);"] = failure
+
+	var running, most atomic.Int32
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	s.BeforeVersion = func(ctx context.Context, index int, sql string) error {
+		now := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			prev := most.Load()
+			if now <= prev || most.CompareAndSwap(prev, now) {
+				break
+			}
+		}
+
+		// Give the other migrations a chance to overlap.
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+
+	errs := s.MigrateAll(context.Background(), dbs, limit)
+	if len(errs) != 1 || !errors.Is(errs[3], failure) {
+		t.Errorf("MigrateAll() = %v, want only database 3 to fail", errs)
+	}
+
+	if most := most.Load(); most > limit {
+		t.Errorf("%d migrations ran at once, want at most %d", most, limit)
+	}
+
+	for i, f := range fakes {
+		if i == 3 {
+			continue
+		}
+		if v := f.results["PRAGMA user_version"][0][0]; v != int64(1) {
+			t.Errorf("database %d is at version %v, want 1", i, v)
+		}
+	}
+}
+
+func TestMigrateAllEmpty(t *testing.T) {
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	if errs := s.MigrateAll(context.Background(), nil, 0); len(errs) != 0 {
+		t.Errorf("MigrateAll() with no databases = %v, want no errors", errs)
+	}
+}
@@ -0,0 +1,75 @@
+package lazymigrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Builder builds a schema string from individual versions by joining them with
+// the magic comment. The schema string never starts or ends with the magic
+// comment, and [Schema.Versions] on it returns the appended versions as long as
+// [Join] would accept them. The zero value is ready to use.
+type Builder struct {
+	// Magic is the magic comment that delimits versions. If empty,
+	// [Delimiter] is used.
+	Magic string
+
+	versions []string
+}
+
+// AppendVersion appends a version to the schema.
+func (b *Builder) AppendVersion(sql string) {
+	b.versions = append(b.versions, sql)
+}
+
+// String returns the schema string.
+func (b *Builder) String() string {
+	magic := b.Magic
+	if magic == "" {
+		magic = Delimiter
+	}
+	return strings.Join(b.versions, "\n"+magic+"\n")
+}
+
+// ErrDelimiterInVersion is returned by [Join] when a version contains a
+// [Delimiter] line, which would split it into several versions.
+var ErrDelimiterInVersion = errors.New("version contains the delimiter")
+
+// Join joins the given versions into a schema string delimited by [Delimiter].
+// It is the inverse of [Schema.Versions]: NewSchema(schema).Versions() returns
+// the given versions exactly. Versions for which that would not hold are
+// rejected with an error:
+//
+//   - An error wrapping [ErrDelimiterInVersion] is returned if a version
+//     contains a [Delimiter] line.
+//   - A version must not end within a block comment, string literal or quoted
+//     identifier, which would hide the delimiters after it.
+//   - A version other than the last one must not end with a carriage return,
+//     which would be taken as part of the line ending before the delimiter.
+//   - The first version must not start with a UTF-8 byte order mark, which is
+//     dropped from the schema string.
+func Join(versions ...string) (string, error) {
+	if len(versions) == 0 {
+		return "", errors.New("schema must have at least one version")
+	}
+
+	if strings.HasPrefix(versions[0], "\uFEFF") {
+		return "", errors.New("version 0 (from 0th) starts with a byte order mark")
+	}
+
+	b := Builder{Magic: Delimiter}
+	for i, version := range versions {
+		if containsMagic(version, Delimiter) {
+			return "", fmt.Errorf("version %d (from 0th): %w", i, ErrDelimiterInVersion)
+		}
+		if state, line := NewSchema(version).endState(); state != "" {
+			return "", fmt.Errorf("version %d (from 0th) ends within the %s started on its line %d", i, state.describe(), line)
+		}
+		if i < len(versions)-1 && strings.HasSuffix(version, "\r") {
+			return "", fmt.Errorf("version %d (from 0th) ends with a carriage return", i)
+		}
+		b.AppendVersion(version)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,122 @@
+package lazymigrate
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuilderRoundTrip(t *testing.T) {
+	versions := []string{
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);\nCREATE TABLE c (id INTEGER);\n",
+		"\n\nALTER TABLE a ADD COLUMN name TEXT;",
+	}
+
+	for _, magic := range []string{"", testMagic} {
+		var b Builder
+		b.Magic = magic
+		for _, version := range versions {
+			b.AppendVersion(version)
+		}
+
+		schema := b.String()
+		if magic == "" {
+			magic = Delimiter
+		}
+
+		if strings.HasPrefix(schema, magic) || strings.HasSuffix(schema, magic) {
+			t.Errorf("schema with magic %q starts or ends with it:\n%s", magic, schema)
+		}
+
+		s := NewSchemaWithMagic(schema, magic)
+		if err := s.Validate(); err != nil {
+			t.Errorf("schema with magic %q is invalid: %v", magic, err)
+		}
+		if got := s.Versions(); !slices.Equal(got, versions) {
+			t.Errorf("Versions() with magic %q = %q, want %q", magic, got, versions)
+		}
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+	}{
+		{"no versions", nil},
+		{"delimiter", []string{"a\n" + Delimiter + "\nb", "c"}},
+		{"block comment", []string{"/* a", "b"}},
+		{"string literal", []string{"SELECT 'x", "y'"}},
+		{"bracketed identifier", []string{"SELECT [x", "y]"}},
+		{"dollar quote", []string{"COMMENT ON TABLE u IS $$user's\n", "$$;"}},
+		{"unterminated last version", []string{"a", "SELECT 'x"}},
+		{"carriage return", []string{"a\r", "b"}},
+		{"byte order mark", []string{"\uFEFFa", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if schema, err := Join(test.versions...); err == nil {
+				t.Errorf("Join() = %q, want an error", schema)
+			}
+		})
+	}
+
+	if _, err := Join("a\n"+Delimiter, "b"); !errors.Is(err, ErrDelimiterInVersion) {
+		t.Errorf("Join() with a delimiter = %v, want an error wrapping ErrDelimiterInVersion", err)
+	}
+}
+
+func TestJoinQuotes(t *testing.T) {
+	versions := []string{
+		"COMMENT ON TABLE u IS $$user's$$;",
+		"CREATE TABLE [it's] (id INTEGER);",
+		"SELECT $a$;",
+		"SELECT 'a\n" + Delimiter + "\nb';",
+	}
+
+	// The delimiter within the string literal is taken as one.
+	if _, err := Join(versions...); !errors.Is(err, ErrDelimiterInVersion) {
+		t.Fatalf("Join() = %v, want an error wrapping ErrDelimiterInVersion", err)
+	}
+
+	versions = versions[:3]
+	schema, err := Join(versions...)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if got := NewSchema(schema).Versions(); !slices.Equal(got, versions) {
+		t.Errorf("Versions() = %q, want %q", got, versions)
+	}
+}
+
+func FuzzJoin(f *testing.F) {
+	f.Add("CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);\n", "")
+	f.Add("/* a", "b", "c")
+	f.Add("SELECT 'x", "y'", "z")
+	f.Add("COMMENT ON TABLE u IS $$user's$$;", "SELECT $a$;", "SELECT $a$ + 1;")
+	f.Add("CREATE TABLE [it's] (id INTEGER);", "a\r\n", "\r\n")
+	f.Add("\uFEFFa", "b", "c")
+	f.Add("a", Delimiter, "  "+Delimiter+"\t")
+	f.Add("a -- /*", "'it''s'", "\"a\"\"b\"")
+
+	f.Fuzz(func(t *testing.T, a, b, c string) {
+		versions := []string{a, b, c}
+
+		// Versions that Join rejects need no round trip.
+		schema, err := Join(versions...)
+		if err != nil {
+			return
+		}
+
+		s := NewSchema(schema)
+		if got := s.Versions(); !slices.Equal(got, versions) {
+			t.Fatalf("Versions() of Join(%q) = %q", versions, got)
+		}
+		if state, _ := s.endState(); state != "" {
+			t.Fatalf("Join(%q) ends within a %s", versions, state.describe())
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package lazymigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionsReordered is returned when [Schema.VerifyChain] is set and the
+// versions that were already applied to the database no longer form the same
+// sequence in the schema, such as when a version was inserted before them.
+var ErrVersionsReordered = errors.New("applied versions were reordered")
+
+// chainTable is the table in which the hash chain of applied versions is
+// recorded when VerifyChain is set.
+const chainTable = "lazymigrate_chain"
+
+// chainHashes returns the hash chain of the first n versions of the schema.
+// The hash of each version covers its own text and the hash of the version
+// before it, so it changes if any earlier version is changed, inserted,
+// removed or moved.
+func (s *Schema) chainHashes(n int) []string {
+	hashes := make([]string, 0, n)
+	prev := ""
+	s.EachVersion(func(i int, version string) error {
+		if i >= n {
+			return errStop
+		}
+		prev = chainLink(prev, version)
+		hashes = append(hashes, prev)
+		return nil
+	})
+	return hashes
+}
+
+// chainLink returns the chain hash of a version given the chain hash of the
+// version before it, which is empty for the first version.
+func chainLink(prev, version string) string {
+	sum := sha256.Sum256([]byte(prev + "\x00" + version))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChain checks the recorded hash chain of the versions before index v
+// against the schema, and reports the first version that diverged. Versions
+// without a recorded hash, such as ones marked as applied by
+// [Schema.Baseline], are not checked.
+func (s *Schema) verifyChain(ctx context.Context, q DBTX, v int) error {
+	var exists bool
+	if err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", chainTable,
+	).Scan(&exists); err != nil {
+		return fmt.Errorf("cannot check for hash chain table: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	rows, err := q.QueryContext(ctx,
+		"SELECT version, hash FROM "+chainTable+" WHERE version <= ? ORDER BY version", v)
+	if err != nil {
+		return fmt.Errorf("cannot read hash chain: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := s.chainHashes(v)
+	for rows.Next() {
+		var version int
+		var hash string
+		if err := rows.Scan(&version, &hash); err != nil {
+			return fmt.Errorf("cannot scan hash chain: %w", err)
+		}
+
+		i := version - 1
+		if i < 0 || i >= len(hashes) {
+			continue
+		}
+		if hashes[i] != hash {
+			return fmt.Errorf("%w: applied versions first diverge at version %d (from 0th)", ErrVersionsReordered, i)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot read hash chain: %w", err)
+	}
+
+	return nil
+}
+
+// recordChain records the chain hash of the version at index i. The hash is
+// carried forward from the recorded hash of the version before it, which was
+// either verified before migrating or recorded by this migration, so that
+// recording each version does not hash the whole schema again.
+func (s *Schema) recordChain(ctx context.Context, q DBTX, i int, version string) error {
+	if _, err := q.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+chainTable+" (version INTEGER PRIMARY KEY, hash TEXT NOT NULL)",
+	); err != nil {
+		return fmt.Errorf("cannot create hash chain table: %w", err)
+	}
+
+	var prev string
+	if i > 0 {
+		err := q.QueryRowContext(ctx,
+			"SELECT hash FROM "+chainTable+" WHERE version = ?", i,
+		).Scan(&prev)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// The version before was not recorded, such as when it was marked
+			// as applied by Baseline, so the chain is computed from the
+			// start once.
+			prev = s.chainHashes(i)[i-1]
+		case err != nil:
+			return fmt.Errorf("cannot read hash chain: %w", err)
+		}
+	}
+
+	if _, err := q.ExecContext(ctx,
+		"INSERT OR REPLACE INTO "+chainTable+" (version, hash) VALUES (?, ?)", i+1, chainLink(prev, version),
+	); err != nil {
+		return fmt.Errorf("cannot record hash chain: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyViolation is a single row reported by PRAGMA foreign_key_check.
+type ForeignKeyViolation struct {
+	// Table is the table containing the row with the broken foreign key.
+	Table string
+	// RowID is the rowid of that row. It is not valid for WITHOUT ROWID
+	// tables.
+	RowID sql.NullInt64
+	// Parent is the table that the foreign key refers to.
+	Parent string
+	// FKID is the index of the foreign key constraint, as reported by PRAGMA
+	// foreign_key_list.
+	FKID int
+}
+
+func (v ForeignKeyViolation) String() string {
+	if v.RowID.Valid {
+		return fmt.Sprintf("%s rowid %d references missing row in %s", v.Table, v.RowID.Int64, v.Parent)
+	}
+	return fmt.Sprintf("%s row references missing row in %s", v.Table, v.Parent)
+}
+
+// ForeignKeyError is returned when [Schema.CheckForeignKeys] is set and the
+// migrated database has foreign key violations.
+type ForeignKeyError struct {
+	Violations []ForeignKeyViolation
+}
+
+func (e *ForeignKeyError) Error() string {
+	lines := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		lines[i] = v.String()
+	}
+	return fmt.Sprintf("%d foreign key violations after migrating: %s",
+		len(e.Violations), strings.Join(lines, "; "))
+}
+
+// IntegrityError is returned when [Schema.CheckIntegrity] is set and PRAGMA
+// integrity_check reports problems with the migrated database.
+type IntegrityError struct {
+	// Problems are the rows reported by PRAGMA integrity_check.
+	Problems []string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check failed after migrating: %s", strings.Join(e.Problems, "; "))
+}
+
+// runChecks runs the checks enabled on the Schema after the migrations have
+// been applied, before they are committed.
+func (s *Schema) runChecks(ctx context.Context, q DBTX) error {
+	if s.CheckForeignKeys {
+		if err := checkForeignKeys(ctx, q); err != nil {
+			return err
+		}
+	}
+	if s.CheckIntegrity {
+		if err := checkIntegrity(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkIntegrity(ctx context.Context, q DBTX) error {
+	rows, err := q.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("cannot check integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return fmt.Errorf("cannot scan integrity check result: %w", err)
+		}
+		problems = append(problems, problem)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot check integrity: %w", err)
+	}
+
+	return integrityResult(problems)
+}
+
+// integrityResult interprets the rows of PRAGMA integrity_check, which is a
+// single "ok" row if there are no problems.
+func integrityResult(rows []string) error {
+	switch {
+	case len(rows) == 0:
+		return errors.New("integrity check returned no results")
+	case len(rows) == 1 && rows[0] == "ok":
+		return nil
+	default:
+		return &IntegrityError{Problems: rows}
+	}
+}
+
+func checkForeignKeys(ctx context.Context, q DBTX) error {
+	rows, err := q.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("cannot check foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var violations []ForeignKeyViolation
+	for rows.Next() {
+		var v ForeignKeyViolation
+		if err := rows.Scan(&v.Table, &v.RowID, &v.Parent, &v.FKID); err != nil {
+			return fmt.Errorf("cannot scan foreign key violation: %w", err)
+		}
+		violations = append(violations, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot check foreign keys: %w", err)
+	}
+
+	if len(violations) > 0 {
+		return &ForeignKeyError{Violations: violations}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     [][]driver.Value
+		ok       bool
+		problems []string
+	}{
+		{
+			name: "ok",
+			rows: [][]driver.Value{{"ok"}},
+			ok:   true,
+		},
+		{
+			name: "no rows",
+			rows: [][]driver.Value{},
+		},
+		{
+			name:     "problem",
+			rows:     [][]driver.Value{{"row 1 missing from index users_name"}},
+			problems: []string{"row 1 missing from index users_name"},
+		},
+		{
+			name:     "ok among problems",
+			rows:     [][]driver.Value{{"ok"}, {"wrong # of entries in index users_name"}},
+			problems: []string{"ok", "wrong # of entries in index users_name"},
+		},
+		{
+			name:     "not exactly ok",
+			rows:     [][]driver.Value{{"OK"}},
+			problems: []string{"OK"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+			f.results["PRAGMA integrity_check"] = test.rows
+
+			err := checkIntegrity(context.Background(), db)
+			if test.ok {
+				if err != nil {
+					t.Errorf("checkIntegrity() = %v, want nil", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("checkIntegrity() = nil, want an error")
+			}
+
+			var ierr *IntegrityError
+			if errors.As(err, &ierr) != (test.problems != nil) {
+				t.Fatalf("checkIntegrity() = %v, want *IntegrityError = %v", err, test.problems != nil)
+			}
+			if ierr != nil && !slices.Equal(ierr.Problems, test.problems) {
+				t.Errorf("IntegrityError.Problems = %q, want %q", ierr.Problems, test.problems)
+			}
+		})
+	}
+}
+
+func TestMigrateCheckIntegrity(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	f.results["PRAGMA integrity_check"] = [][]driver.Value{{"row 1 missing from index users_name"}}
+
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	s.CheckIntegrity = true
+
+	var ierr *IntegrityError
+	if err := s.Migrate(context.Background(), db); !errors.As(err, &ierr) {
+		t.Fatalf("Migrate() = %v, want an *IntegrityError", err)
+	}
+
+	if stmts := f.statements(); stmts[len(stmts)-1] != "ROLLBACK" {
+		t.Errorf("executed %q, want the transaction to be rolled back", stmts)
+	}
+}
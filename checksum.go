@@ -0,0 +1,67 @@
+package lazymigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrChecksumMismatch is returned when checksums are verified and a version
+// that was already applied to the database has since been changed.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksum returns the checksum of a version.
+func checksum(version string) string {
+	sum := sha256.Sum256([]byte(version))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumStore returns the version store as a [ChecksumStore], or an error
+// if it does not support checksums.
+func (s *Schema) checksumStore() (ChecksumStore, error) {
+	store, ok := s.store.(ChecksumStore)
+	if !ok {
+		return nil, fmt.Errorf("version store %T does not support checksums", s.store)
+	}
+	return store, nil
+}
+
+// verifyChecksums checks that the versions before index v still match the
+// checksums recorded when they were applied. Versions without a recorded
+// checksum are not checked.
+func (s *Schema) verifyChecksums(ctx context.Context, q DBTX, v int) error {
+	store, err := s.checksumStore()
+	if err != nil {
+		return err
+	}
+
+	sums, err := store.Checksums(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	err = s.EachVersion(func(i int, version string) error {
+		if i >= v {
+			return errStop
+		}
+		if sum, ok := sums[i]; ok && sum != checksum(version) {
+			return fmt.Errorf("%w: version %d (from 0th) was changed after being applied", ErrChecksumMismatch, i)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+	return nil
+}
+
+// recordChecksum records the checksum of the version at index i.
+func (s *Schema) recordChecksum(ctx context.Context, q DBTX, i int, version string) error {
+	store, err := s.checksumStore()
+	if err != nil {
+		return err
+	}
+	return store.SetChecksum(ctx, q, i, checksum(version))
+}
@@ -0,0 +1,151 @@
+package lazymigrate
+
+import "strings"
+
+// StripComments returns sql with all -- line comments and /* */ block
+// comments removed. Comment markers inside string literals, quoted or
+// bracketed identifiers and dollar-quoted strings are kept. A line comment is
+// removed up to but not including its line ending, and a block comment is
+// replaced by a single space so that the tokens around it stay apart.
+func StripComments(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j != -1 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j != -1 {
+				i += 2 + j + 2
+			} else {
+				i = len(sql)
+			}
+			b.WriteByte(' ')
+		case isQuote(c):
+			j := skipQuoted(sql, i)
+			if j == -1 {
+				j = len(sql)
+			}
+			b.WriteString(sql[i:j])
+			i = j
+		case c == '[':
+			j := strings.IndexByte(sql[i:], ']')
+			if j == -1 {
+				j = len(sql)
+			} else {
+				j += i + 1
+			}
+			b.WriteString(sql[i:j])
+			i = j
+		case c == '$' && dollarTag(sql[i:]) != "":
+			// A tag that is never closed is an SQLite parameter.
+			tag := dollarTag(sql[i:])
+			j := i + len(tag)
+			if k := strings.Index(sql[j:], tag); k != -1 {
+				j += k + len(tag)
+			}
+			b.WriteString(sql[i:j])
+			i = j
+		case isWordStart(c):
+			j := i + 1
+			for j < len(sql) && isWordPart(sql[j]) {
+				j++
+			}
+			b.WriteString(sql[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// lineState is what a line of the schema string ends within. It is the text
+// that ends the block comment, string literal or quoted identifier that the
+// line ends within, such as "*/", "'", "]" or "$body$", or an empty string for
+// plain SQL.
+type lineState string
+
+// describe returns a description of the comment or literal that the state is
+// within.
+func (state lineState) describe() string {
+	switch state[0] {
+	case '*':
+		return "block comment"
+	case '\'':
+		return "string literal"
+	case '$':
+		return "dollar-quoted string " + string(state)
+	default:
+		return "quoted identifier"
+	}
+}
+
+// scanLine returns the state at the end of line, given the state at its
+// start. It knows the same tokens as splitStatements. Line comments end with
+// the line, so they never carry over.
+//
+// A dollar tag such as $body$ only starts a dollar-quoted string if closes
+// reports that the tag appears again after the line, before the next magic
+// comment line. Otherwise, it is an SQLite parameter like $a$, the same as in
+// splitStatements, which only sees a single version.
+func scanLine(line string, state lineState, closes func(tag string) bool) lineState {
+	for i := 0; i < len(line); {
+		if state != "" {
+			j := strings.Index(line[i:], string(state))
+			if j == -1 {
+				return state
+			}
+			i += j + len(state)
+			// A doubled quote is an escaped quote.
+			if isQuote(state[0]) && i < len(line) && line[i] == state[0] {
+				i++
+				continue
+			}
+			state = ""
+			continue
+		}
+
+		switch c := line[i]; {
+		case c == '-' && strings.HasPrefix(line[i:], "--"):
+			return ""
+		case c == '/' && strings.HasPrefix(line[i:], "/*"):
+			state = "*/"
+			i += 2
+		case isQuote(c):
+			state = lineState(c)
+			i++
+		case c == '[':
+			state = "]"
+			i++
+		case c == '$' && dollarTag(line[i:]) != "":
+			tag := dollarTag(line[i:])
+			i += len(tag)
+			if strings.Contains(line[i:], tag) || closes(tag) {
+				state = lineState(tag)
+			}
+		case isWordStart(c):
+			// Skip the whole word, since a $ within it is not a dollar tag.
+			i++
+			for i < len(line) && isWordPart(line[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	return state
+}
+
+func isQuote(c byte) bool {
+	return c == '\'' || c == '"' || c == '`'
+}
@@ -0,0 +1,125 @@
+package lazymigrate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestVersionsComments(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []string
+	}{
+		{
+			name:   "block comment",
+			schema: "a\n/*\n-- migrate\n*/\nb",
+			want:   []string{"a\n/*\n-- migrate\n*/\nb"},
+		},
+		{
+			name:   "closed block comment",
+			schema: "a /* x */\n-- migrate\nb",
+			want:   []string{"a /* x */", "b"},
+		},
+		{
+			name:   "block comment in line comment",
+			schema: "a -- /*\n-- migrate\nb",
+			want:   []string{"a -- /*", "b"},
+		},
+		{
+			name:   "multi-line string",
+			schema: "INSERT INTO t VALUES ('\n-- migrate\n');\n-- migrate\nb",
+			want:   []string{"INSERT INTO t VALUES ('\n-- migrate\n');", "b"},
+		},
+		{
+			name:   "escaped quote",
+			schema: "SELECT 'it''s';\n-- migrate\nb",
+			want:   []string{"SELECT 'it''s';", "b"},
+		},
+		{
+			name:   "quoted identifier",
+			schema: "CREATE TABLE \"it's\" (id INTEGER);\n-- migrate\nb",
+			want:   []string{"CREATE TABLE \"it's\" (id INTEGER);", "b"},
+		},
+		{
+			name:   "bracketed identifier",
+			schema: "CREATE TABLE [it's] (id INTEGER);\n-- migrate\nb",
+			want:   []string{"CREATE TABLE [it's] (id INTEGER);", "b"},
+		},
+		{
+			name:   "dollar quote",
+			schema: "COMMENT ON TABLE u IS $$user's$$;\n-- migrate\nb",
+			want:   []string{"COMMENT ON TABLE u IS $$user's$$;", "b"},
+		},
+		{
+			name:   "multi-line dollar quote",
+			schema: "CREATE FUNCTION f() AS $body$\nSELECT 'it''s /*';\n$body$;\n-- migrate\nb",
+			want:   []string{"CREATE FUNCTION f() AS $body$\nSELECT 'it''s /*';\n$body$;", "b"},
+		},
+		{
+			name:   "dollar parameter",
+			schema: "SELECT $a$;\n-- migrate\nSELECT $a$ + 1;\n-- migrate\nc",
+			want:   []string{"SELECT $a$;", "SELECT $a$ + 1;", "c"},
+		},
+		{
+			name:   "dollar in identifier",
+			schema: "SELECT a$b$ FROM t;\n-- migrate\nSELECT c$b$ FROM t;",
+			want:   []string{"SELECT a$b$ FROM t;", "SELECT c$b$ FROM t;"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSchemaWithMagic(test.schema, testMagic)
+			if got := s.Versions(); !slices.Equal(got, test.want) {
+				t.Errorf("Versions() = %q, want %q", got, test.want)
+			}
+			if err := s.Validate(); err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestValidateUnterminated(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"block comment", "a /* x\n-- migrate\nb"},
+		{"string literal", "SELECT 'it;\n-- migrate\nb"},
+		{"quoted identifier", "SELECT \"a;\n-- migrate\nb"},
+		{"bracketed identifier", "SELECT [a;\n-- migrate\nb"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSchemaWithMagic(test.schema, testMagic)
+			if err := s.Validate(); err == nil {
+				t.Errorf("Validate() = nil, want an error for versions %q", s.Versions())
+			}
+		})
+	}
+}
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT 1; -- comment\nSELECT 2;", "SELECT 1; \nSELECT 2;"},
+		{"SELECT /* a */ 1;", "SELECT   1;"},
+		{"SELECT 1; /* unterminated", "SELECT 1;  "},
+		{"SELECT '-- not a comment';", "SELECT '-- not a comment';"},
+		{`SELECT "/* not */" FROM t;`, `SELECT "/* not */" FROM t;`},
+		{"SELECT [--x] FROM t;", "SELECT [--x] FROM t;"},
+		{"SELECT $$it's -- not$$; -- comment", "SELECT $$it's -- not$$; "},
+		{"SELECT $a$; -- comment", "SELECT $a$; "},
+	}
+
+	for _, test := range tests {
+		if got := StripComments(test.sql); got != test.want {
+			t.Errorf("StripComments(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package lazymigrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// NewSchemaFromDir returns a new Schema with one version per .sql file in the
+// directory dir of fsys. The files are applied in lexical order of their
+// names, so they should be numbered with zero padding, such as 001.sql,
+// 002.sql and so on. Subdirectories and files without the .sql extension are
+// ignored, and an error is returned if no files are left. It is meant to be
+// used with embed.FS.
+//
+// The files are joined with [Delimiter] using a [Builder], so the returned
+// Schema behaves exactly like one made from a single schema file. A file must
+// therefore not contain a [Delimiter] line itself.
+func NewSchemaFromDir(fsys fs.FS, dir string) (*Schema, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema directory: %w", err)
+	}
+
+	// fs.ReadDir already sorts the entries by name.
+	var b Builder
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		name := path.Join(dir, entry.Name())
+
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read schema file: %w", err)
+		}
+
+		if containsMagic(string(src), Delimiter) {
+			return nil, fmt.Errorf("schema file %s: %w", name, ErrDelimiterInVersion)
+		}
+
+		b.AppendVersion(string(src))
+	}
+
+	if len(b.versions) == 0 {
+		return nil, fmt.Errorf("schema directory %s has no .sql files", dir)
+	}
+
+	return NewSchema(b.String()), nil
+}
+
+// containsMagic returns true if any line of src is the magic comment.
+func containsMagic(src, magic string) bool {
+	for _, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) == magic {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package lazymigrate
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewSchemaFromDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/010.sql":       {Data: []byte("CREATE TABLE c (id INTEGER);")},
+		"migrations/001.sql":       {Data: []byte("CREATE TABLE a (id INTEGER);\n")},
+		"migrations/002.sql":       {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"migrations/README.md":     {Data: []byte("# Migrations")},
+		"migrations/003.sql.bak":   {Data: []byte("DROP TABLE a;")},
+		"migrations/old/004.sql":   {Data: []byte("DROP TABLE b;")},
+		"migrations.sql":           {Data: []byte("DROP TABLE c;")},
+		"other/001.sql":            {Data: []byte("DROP TABLE d;")},
+		"migrations/old/README.md": {Data: []byte("# Old migrations")},
+	}
+
+	s, err := NewSchemaFromDir(fsys, "migrations")
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	want := []string{
+		"CREATE TABLE a (id INTEGER);\n",
+		"CREATE TABLE b (id INTEGER);",
+		"CREATE TABLE c (id INTEGER);",
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+}
+
+func TestNewSchemaFromDirErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "no files",
+			fsys: fstest.MapFS{"migrations/README.md": {Data: []byte("# Migrations")}},
+		},
+		{
+			name: "missing directory",
+			fsys: fstest.MapFS{"other/001.sql": {Data: []byte("SELECT 1;")}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewSchemaFromDir(test.fsys, "migrations"); err == nil {
+				t.Error("NewSchemaFromDir() = nil error, want an error")
+			}
+		})
+	}
+
+	fsys := fstest.MapFS{"migrations/001.sql": {Data: []byte("SELECT 1;\n" + Delimiter + "\nSELECT 2;")}}
+	if _, err := NewSchemaFromDir(fsys, "migrations"); !errors.Is(err, ErrDelimiterInVersion) {
+		t.Errorf("NewSchemaFromDir() with a delimiter = %v, want an error wrapping ErrDelimiterInVersion", err)
+	}
+}
@@ -0,0 +1,134 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DownMarker is the line that separates the up and down sections of a
+// version. Everything before it is applied when migrating up, and everything
+// after it is applied by [Schema.MigrateDownTo] to undo the version. Like the
+// magic comment, leading and trailing whitespace on the line is ignored.
+const DownMarker = "-- DOWN --"
+
+// ErrNoDownMigration is returned by [Schema.MigrateDownTo] when a version that
+// would have to be undone has no down section.
+var ErrNoDownMigration = errors.New("version has no down section")
+
+// splitDown splits a version into its up and down sections on the first
+// [DownMarker] line. downStart is the byte offset of the down section within
+// the version. If there is no down section, up is the whole version and
+// downStart is -1. Like magic comments, a marker line within a block comment,
+// string literal or quoted identifier is ignored.
+func splitDown(version string) (up, down string, downStart int) {
+	var state lineState
+	for i := 0; i < len(version); {
+		end := len(version)
+		next := len(version)
+		if j := strings.IndexByte(version[i:], '\n'); j != -1 {
+			end = i + j
+			next = end + 1
+		}
+
+		line := version[i:end]
+		if state == "" && strings.TrimSpace(line) == DownMarker {
+			up = strings.TrimSuffix(strings.TrimSuffix(version[:i], "\n"), "\r")
+			return up, version[next:], next
+		}
+
+		state = scanLine(line, state, func(tag string) bool {
+			return strings.Contains(version[next:], tag)
+		})
+		i = next
+	}
+	return version, "", -1
+}
+
+// MigrateDownTo undoes versions of the schema until the database is at the
+// target version, by applying the down section of each version in reverse
+// order, from the current version down to target. All versions are undone in a
+// single transaction, which is rolled back if any of them fails.
+//
+// If a version that has to be undone has no down section, nothing is undone
+// and an error wrapping [ErrNoDownMigration] is returned. An empty down
+// section is allowed and undoes nothing. The hooks are not called for down
+// sections.
+func (s *Schema) MigrateDownTo(ctx context.Context, db *sql.DB, target int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return s.inTx(ctx, conn, nil, func(tx DBTX) error {
+		v, latest, err := s.startVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if v > latest {
+			return aheadError(v, latest)
+		}
+
+		if target < 0 {
+			return fmt.Errorf("cannot migrate down to negative version %d", target)
+		}
+
+		if target > v {
+			return fmt.Errorf("cannot migrate up to version %d from version %d", target, v)
+		}
+
+		if v == target {
+			return nil
+		}
+
+		versions := s.Versions()
+
+		numbers, err := s.numbers()
+		if err != nil {
+			return err
+		}
+
+		// Check every version first, so that nothing is run if one of them
+		// cannot be undone.
+		for i := v - 1; i >= target; i-- {
+			if _, _, downStart := splitDown(versions[i]); downStart == -1 {
+				return fmt.Errorf("cannot undo migration %d (from 0th): %w", i, ErrNoDownMigration)
+			}
+		}
+
+		for i := v - 1; i >= target; i-- {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("cannot undo migration %d (from 0th): %w", i, err)
+			}
+
+			s.logf("undoing version %d/%d", i+1, v)
+
+			version, err := s.rewrite(i, versions[i])
+			if err != nil {
+				return err
+			}
+
+			_, down, downStart := splitDown(version)
+			if downStart == -1 {
+				return fmt.Errorf("cannot undo migration %d (from 0th): %w", i, ErrNoDownMigration)
+			}
+
+			if err := s.execStatements(ctx, tx, i, down, downStart); err != nil {
+				return err
+			}
+
+			if s.ManageVersion {
+				if err := s.setVersion(ctx, tx, i, numbers); err != nil {
+					return err
+				}
+			}
+		}
+
+		s.logf("done")
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package lazymigrate
+
+import "testing"
+
+func TestSplitDown(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		up        string
+		down      string
+		downStart int
+	}{
+		{
+			name:      "no down section",
+			version:   "CREATE TABLE a (id INTEGER);",
+			up:        "CREATE TABLE a (id INTEGER);",
+			downStart: -1,
+		},
+		{
+			name:      "down section",
+			version:   "CREATE TABLE a (id INTEGER);\n-- DOWN --\nDROP TABLE a;",
+			up:        "CREATE TABLE a (id INTEGER);",
+			down:      "DROP TABLE a;",
+			downStart: 40,
+		},
+		{
+			name:      "whitespace and CRLF",
+			version:   "CREATE TABLE a (id INTEGER);\r\n  -- DOWN --  \r\nDROP TABLE a;",
+			up:        "CREATE TABLE a (id INTEGER);",
+			down:      "DROP TABLE a;",
+			downStart: 46,
+		},
+		{
+			name:      "empty down section",
+			version:   "CREATE TABLE a (id INTEGER);\n-- DOWN --\n",
+			up:        "CREATE TABLE a (id INTEGER);",
+			downStart: 40,
+		},
+		{
+			name:      "first marker",
+			version:   "SELECT 1;\n-- DOWN --\nSELECT 2;\n-- DOWN --\nSELECT 3;",
+			up:        "SELECT 1;",
+			down:      "SELECT 2;\n-- DOWN --\nSELECT 3;",
+			downStart: 21,
+		},
+		{
+			name:      "marker in block comment",
+			version:   "/*\n-- DOWN --\n*/\nSELECT 1;",
+			up:        "/*\n-- DOWN --\n*/\nSELECT 1;",
+			downStart: -1,
+		},
+		{
+			name:      "marker in string",
+			version:   "INSERT INTO t VALUES ('\n-- DOWN --\n');",
+			up:        "INSERT INTO t VALUES ('\n-- DOWN --\n');",
+			downStart: -1,
+		},
+		{
+			name:      "marker in dollar-quoted string",
+			version:   "SELECT $body$\n-- DOWN --\n$body$;",
+			up:        "SELECT $body$\n-- DOWN --\n$body$;",
+			downStart: -1,
+		},
+		{
+			name:      "marker after block comment",
+			version:   "/* -- DOWN -- */\nSELECT 1;\n-- DOWN --\nSELECT 2;",
+			up:        "/* -- DOWN -- */\nSELECT 1;",
+			down:      "SELECT 2;",
+			downStart: 38,
+		},
+		{
+			name:      "marker in line comment",
+			version:   "SELECT 1; -- -- DOWN --\nSELECT 2;",
+			up:        "SELECT 1; -- -- DOWN --\nSELECT 2;",
+			downStart: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			up, down, downStart := splitDown(test.version)
+			if up != test.up || down != test.down || downStart != test.downStart {
+				t.Errorf("splitDown(%q) = (%q, %q, %d), want (%q, %q, %d)",
+					test.version, up, down, downStart, test.up, test.down, test.downStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package lazymigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSchemaDrift is returned by [Schema.CheckDrift] when the live schema of
+// the database no longer matches the one recorded for its version.
+var ErrSchemaDrift = errors.New("live schema has drifted")
+
+// driftTable is the table in which [Schema.CheckDrift] records the hash of the
+// live schema for each version.
+const driftTable = "lazymigrate_schema_hash"
+
+// CheckDrift detects changes made to the database schema outside of the
+// migrations, such as manual edits in production. It hashes the live schema
+// from sqlite_master and compares it against the hash recorded by a previous
+// call for the same database version. If there is no hash for the version
+// yet, the current one is recorded and nil is returned, so it should be called
+// right after migrating.
+//
+// The hashes are kept in the lazymigrate_schema_hash table. Before hashing,
+// the SQL of each object is normalized so that changes to whitespace,
+// comments, letter case and identifier quoting do not count as drift.
+// Internal SQLite tables, the tables of lazymigrate itself and the version
+// table of a [TableStore] are ignored.
+func (s *Schema) CheckDrift(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return s.inTx(ctx, conn, nil, func(tx DBTX) error {
+		v, _, err := s.startVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		hash, err := s.liveSchemaHash(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driftTable+
+			" (version INTEGER PRIMARY KEY, hash TEXT NOT NULL)")
+		if err != nil {
+			return fmt.Errorf("cannot create schema hash table: %w", err)
+		}
+
+		var recorded string
+		err = tx.QueryRowContext(ctx,
+			"SELECT hash FROM "+driftTable+" WHERE version = ?", v).Scan(&recorded)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO "+driftTable+" (version, hash) VALUES (?, ?)", v, hash)
+			if err != nil {
+				return fmt.Errorf("cannot record schema hash: %w", err)
+			}
+			return nil
+		case err != nil:
+			return fmt.Errorf("cannot read schema hash: %w", err)
+		case recorded != hash:
+			return fmt.Errorf("%w: schema at version %d was changed outside of migrations", ErrSchemaDrift, v)
+		default:
+			return nil
+		}
+	})
+}
+
+// liveSchemaHash returns a hash of the normalized SQL of every object in
+// sqlite_master, except for the ones that lazymigrate manages itself.
+func (s *Schema) liveSchemaHash(ctx context.Context, q DBTX) (string, error) {
+	ignored := s.ownTables()
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY type, name`)
+	if err != nil {
+		return "", fmt.Errorf("cannot read live schema: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	for rows.Next() {
+		var typ, name, table, src string
+		if err := rows.Scan(&typ, &name, &table, &src); err != nil {
+			return "", fmt.Errorf("cannot scan live schema: %w", err)
+		}
+
+		if containsFold(ignored, table) {
+			continue
+		}
+
+		fmt.Fprintf(h, "%s\t%s\t%s\n", typ, strings.ToLower(name), normalizeSQL(src))
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("cannot read live schema: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ownTables returns the tables that lazymigrate itself may create.
+func (s *Schema) ownTables() []string {
+	tables := []string{driftTable, chainTable}
+	if store, ok := s.store.(TableStore); ok {
+		tables = append(tables, store.Table)
+	}
+	return tables
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeSQL normalizes a statement from sqlite_master for comparison.
+// Comments count as whitespace. Whitespace is collapsed, and removed entirely
+// around punctuation. Everything
+// outside of string literals is lowercased, and identifiers quoted with double
+// quotes, backticks or brackets are unquoted if they do not need quoting.
+func normalizeSQL(src string) string {
+	var b strings.Builder
+	space := false
+
+	write := func(s string) {
+		if space && b.Len() > 0 && !isPunct(lastByte(&b)) && !isPunct(s[0]) {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case isSpace(c):
+			space = true
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			j := strings.IndexByte(src[i:], '\n')
+			if j == -1 {
+				j = len(src) - i
+			}
+			space = true
+			i += j
+		case strings.HasPrefix(src[i:], "/*"):
+			j := strings.Index(src[i+2:], "*/")
+			if j == -1 {
+				j = len(src) - i - 4
+			}
+			space = true
+			i += j + 4
+		case c == '\'':
+			j := skipQuoted(src, i)
+			if j == -1 {
+				j = len(src)
+			}
+			write(src[i:j])
+			i = j
+		case c == '"' || c == '`' || c == '[':
+			var j int
+			var ident string
+			if c == '[' {
+				j = strings.IndexByte(src[i:], ']')
+				if j == -1 {
+					j = len(src)
+				} else {
+					j += i + 1
+				}
+				ident = strings.TrimSuffix(src[i+1:j], "]")
+			} else {
+				j = skipQuoted(src, i)
+				if j == -1 {
+					j = len(src)
+				}
+				ident = strings.TrimSuffix(src[i+1:j], string(c))
+				ident = strings.ReplaceAll(ident, string(c)+string(c), string(c))
+			}
+			write(normalizeIdent(ident))
+			i = j
+		default:
+			j := i + 1
+			if isWordPart(c) {
+				for j < len(src) && isWordPart(src[j]) {
+					j++
+				}
+			}
+			write(strings.ToLower(src[i:j]))
+			i = j
+		}
+	}
+
+	return b.String()
+}
+
+// normalizeIdent returns the identifier lowercased, quoted with "" only if it
+// is not a plain word.
+func normalizeIdent(ident string) string {
+	ident = strings.ToLower(ident)
+	plain := ident != "" && isWordStart(ident[0])
+	for i := 0; plain && i < len(ident); i++ {
+		plain = isWordPart(ident[i])
+	}
+	if plain {
+		return ident
+	}
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("(),;=.", c) != -1
+}
+
+func lastByte(b *strings.Builder) byte {
+	s := b.String()
+	return s[len(s)-1]
+}
@@ -0,0 +1,80 @@
+package lazymigrate
+
+import "testing"
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "plain",
+			src:  "create table a (id integer)",
+			want: "create table a(id integer)",
+		},
+		{
+			name: "whitespace",
+			src:  "CREATE  TABLE\ta\n(\n\tid INTEGER ,\r\n\tname TEXT\n)",
+			want: "create table a(id integer,name text)",
+		},
+		{
+			name: "letter case",
+			src:  "CREATE TABLE Users (ID Integer PRIMARY KEY)",
+			want: "create table users(id integer primary key)",
+		},
+		{
+			name: "line comments",
+			src:  "CREATE TABLE a ( -- the table\n\tid INTEGER -- the id\n)",
+			want: "create table a(id integer)",
+		},
+		{
+			name: "block comments",
+			src:  "CREATE /* comment */ TABLE a (id /* the\nid */ INTEGER)/*",
+			want: "create table a(id integer)",
+		},
+		{
+			name: "comment between words",
+			src:  "CREATE TABLE a (id/**/INTEGER)",
+			want: "create table a(id integer)",
+		},
+		{
+			name: "double quotes",
+			src:  `CREATE TABLE "a" ("id" INTEGER)`,
+			want: "create table a(id integer)",
+		},
+		{
+			name: "backticks and brackets",
+			src:  "CREATE TABLE `a` ([id] INTEGER)",
+			want: "create table a(id integer)",
+		},
+		{
+			name: "quoted identifier that needs quotes",
+			src:  `CREATE TABLE "my table" ([the id] INTEGER, "say ""hi""" TEXT)`,
+			want: `create table "my table"("the id" integer,"say ""hi""" text)`,
+		},
+		{
+			name: "string literals",
+			src:  "CREATE TABLE a (kind TEXT DEFAULT 'Big  -- /* Value */')",
+			want: "create table a(kind text default 'Big  -- /* Value */')",
+		},
+		{
+			name: "escaped quote in string literal",
+			src:  "CREATE TABLE a (kind TEXT DEFAULT 'it''s')",
+			want: "create table a(kind text default 'it''s')",
+		},
+		{
+			name: "minus",
+			src:  "CREATE TABLE a (n INTEGER DEFAULT -1 CHECK (n > - 2))",
+			want: "create table a(n integer default -1 check(n > - 2))",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeSQL(test.src); got != test.want {
+				t.Errorf("normalizeSQL(%q) = %q, want %q", test.src, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is the state of a fake database that records the statements run
+// against it. Queries return the rows set for them in results, and fail with
+// the error set for them in errs. Statements in hang block until their context
+// is done.
+type fakeDB struct {
+	mu      sync.Mutex
+	execs   []string
+	results map[string][][]driver.Value
+	errs    map[string]error
+	hang    map[string]bool
+}
+
+// newFakeDB returns a new fake database and a *sql.DB connected to it. The
+// user_version pragma starts at v and is updated when set.
+func newFakeDB(t *testing.T, v int) (*fakeDB, *sql.DB) {
+	f := &fakeDB{
+		results: map[string][][]driver.Value{
+			"PRAGMA user_version": {{int64(v)}},
+		},
+		errs: map[string]error{},
+		hang: map[string]bool{},
+	}
+
+	db := sql.OpenDB(fakeConnector{f})
+	t.Cleanup(func() { db.Close() })
+
+	return f, db
+}
+
+// statements returns the statements executed so far.
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.execs...)
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.db}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake driver must be used with its connector")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+var (
+	_ driver.ExecerContext  = fakeConn{}
+	_ driver.QueryerContext = fakeConn{}
+)
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver does not support prepared statements")
+}
+
+func (c fakeConn) Close() error { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	if _, err := c.ExecContext(context.Background(), "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return fakeTx{c}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.execs = append(c.db.execs, query)
+
+	if c.db.hang[query] {
+		c.db.mu.Unlock()
+		<-ctx.Done()
+		c.db.mu.Lock()
+		return nil, errors.New("interrupted")
+	}
+
+	if err := c.db.errs[query]; err != nil {
+		return nil, err
+	}
+
+	if v, ok := strings.CutPrefix(query, "PRAGMA user_version = "); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		c.db.results["PRAGMA user_version"] = [][]driver.Value{{n}}
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if err := c.db.errs[query]; err != nil {
+		return nil, err
+	}
+
+	rows, ok := c.db.results[query]
+	if !ok {
+		return nil, errors.New("fake driver has no results for " + query)
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeTx struct{ conn fakeConn }
+
+func (tx fakeTx) Commit() error {
+	_, err := tx.conn.ExecContext(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (tx fakeTx) Rollback() error {
+	_, err := tx.conn.ExecContext(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"value"}
+	}
+	cols := make([]string, len(r.rows[0]))
+	for i := range cols {
+		cols[i] = "column" + strconv.Itoa(i)
+	}
+	return cols
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -2,4 +2,7 @@ module libdb.so/lazymigrate
 
 go 1.21.0
 
-require github.com/pkg/errors v0.9.1
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/pkg/errors v0.9.1
+)
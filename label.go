@@ -0,0 +1,127 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Labels returns the label of each version of the schema, or an empty string
+// for versions without one. The label of a version is the text of the line
+// comment on its first non-blank line, with an optional "version:" prefix
+// removed. For example, a version starting with
+//
+//	-- version: 2024-01-15 add_users
+//
+// has the label "2024-01-15 add_users".
+func (s *Schema) Labels() []string {
+	var labels []string
+	s.EachVersion(func(i int, version string) error {
+		labels = append(labels, versionLabel(version))
+		return nil
+	})
+	return labels
+}
+
+func versionLabel(version string) string {
+	for version != "" {
+		line := version
+		if i := strings.IndexByte(version, '\n'); i != -1 {
+			line, version = version[:i], version[i+1:]
+		} else {
+			version = ""
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			return ""
+		}
+
+		comment = strings.TrimSpace(comment)
+		if label, ok := strings.CutPrefix(comment, "version:"); ok {
+			comment = strings.TrimSpace(label)
+		}
+		return comment
+	}
+	return ""
+}
+
+// MigrateToLabel is like [Schema.MigrateTo], but it migrates up to and
+// including the version with the given label. See [Schema.Labels] for how
+// labels are found. An error is returned if no version or more than one
+// version has the label.
+func (s *Schema) MigrateToLabel(ctx context.Context, db *sql.DB, label string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	i, err := s.labelIndex(label)
+	if err != nil {
+		return err
+	}
+	return s.MigrateTo(ctx, db, i+1)
+}
+
+// labelIndex returns the index of the version with the given label.
+func (s *Schema) labelIndex(label string) (int, error) {
+	if label == "" {
+		return 0, errors.New("label must not be empty")
+	}
+
+	index := -1
+	for i, l := range s.Labels() {
+		if l != label {
+			continue
+		}
+		if index != -1 {
+			return 0, fmt.Errorf("label %q is ambiguous: versions %d and %d (from 0th) both have it", label, index, i)
+		}
+		index = i
+	}
+
+	if index == -1 {
+		return 0, fmt.Errorf("no version has label %q", label)
+	}
+
+	return index, nil
+}
+
+// VersionStatus is the status of a single version of the schema, as returned
+// by [Schema.StatusDetailed].
+type VersionStatus struct {
+	// Index is the index of the version in [Schema.Versions].
+	Index int
+	// Label is the label of the version, as returned by [Schema.Labels].
+	Label string
+	// Applied is true if the version has been applied to the database.
+	Applied bool
+}
+
+// StatusDetailed returns the status of each version of the schema against the
+// current version of the database, as read by [Schema.Status]. It is meant
+// for rendering a status table in a command-line tool.
+func (s *Schema) StatusDetailed(ctx context.Context, db *sql.DB) ([]VersionStatus, error) {
+	current, _, err := s.Status(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := s.Labels()
+	statuses := make([]VersionStatus, len(labels))
+	for i, label := range labels {
+		statuses[i] = VersionStatus{
+			Index:   i,
+			Label:   label,
+			Applied: i < current,
+		}
+	}
+
+	return statuses, nil
+}
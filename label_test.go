@@ -0,0 +1,133 @@
+package lazymigrate
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const labelSchema = "-- version: 2024-01-15 add_a\nCREATE TABLE a (id INTEGER);\n" +
+	"-- migrate\n" +
+	"CREATE TABLE b (id INTEGER);\n" +
+	"-- migrate\n" +
+	"\n  -- add_c\nCREATE TABLE c (id INTEGER);"
+
+func TestLabels(t *testing.T) {
+	want := []string{"2024-01-15 add_a", "", "add_c"}
+	if got := NewSchemaWithMagic(labelSchema, testMagic).Labels(); !slices.Equal(got, want) {
+		t.Errorf("Labels() = %q, want %q", got, want)
+	}
+}
+
+func TestVersionLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{"prefix", "-- version: add_a\nSELECT 1;", "add_a"},
+		{"no prefix", "-- add_a\nSELECT 1;", "add_a"},
+		{"no space", "--version:add_a\nSELECT 1;", "add_a"},
+		{"blank lines", "\n\t\n-- add_a\r\nSELECT 1;", "add_a"},
+		{"statement first", "SELECT 1;\n-- add_a", ""},
+		{"block comment", "/* add_a */\nSELECT 1;", ""},
+		{"comment only", "-- add_a", "add_a"},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := versionLabel(test.version); got != test.want {
+				t.Errorf("versionLabel(%q) = %q, want %q", test.version, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateToLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		label  string
+		want   []string
+		err    string
+	}{
+		{
+			name:  "first",
+			label: "2024-01-15 add_a",
+			want:  []string{"CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1"},
+		},
+		{
+			name:  "last",
+			label: "add_c",
+			want: []string{
+				"CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1",
+				"CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 2",
+				"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 3",
+			},
+		},
+		{
+			name:  "missing",
+			label: "add_b",
+			err:   `no version has label "add_b"`,
+		},
+		{
+			name: "empty",
+			err:  "label must not be empty",
+		},
+		{
+			name:   "ambiguous",
+			schema: strings.Replace(labelSchema, "-- add_c", "-- version: 2024-01-15 add_a", 1),
+			label:  "2024-01-15 add_a",
+			err:    "versions 0 and 2 (from 0th) both have it",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+
+			src := test.schema
+			if src == "" {
+				src = labelSchema
+			}
+
+			err := NewSchemaWithMagic(src, testMagic).MigrateToLabel(context.Background(), db, test.label)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatal("cannot migrate:", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("MigrateToLabel() = %v, want an error containing %q", err, test.err)
+			}
+
+			var got []string
+			for _, stmt := range f.statements() {
+				if strings.HasPrefix(stmt, "CREATE") || strings.HasPrefix(stmt, "PRAGMA user_version =") {
+					got = append(got, stmt)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("executed %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatusDetailed(t *testing.T) {
+	_, db := newFakeDB(t, 2)
+
+	got, err := NewSchemaWithMagic(labelSchema, testMagic).StatusDetailed(context.Background(), db)
+	if err != nil {
+		t.Fatal("cannot get status:", err)
+	}
+
+	want := []VersionStatus{
+		{Index: 0, Label: "2024-01-15 add_a", Applied: true},
+		{Index: 1, Label: "", Applied: true},
+		{Index: 2, Label: "add_c", Applied: false},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("StatusDetailed() = %+v, want %+v", got, want)
+	}
+}
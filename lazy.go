@@ -0,0 +1,44 @@
+package lazymigrate
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// lazySchema reads the schema string of a Schema on first use.
+type lazySchema struct {
+	once sync.Once
+	fsys fs.FS
+	name string
+	err  error
+}
+
+// NewLazySchemaFromFS is like [NewSchemaFromFS], but it does not read the file
+// until the Schema is first used, so it cannot fail. If reading the file
+// fails, the error is returned by the methods that access the database, such
+// as [Schema.Migrate]. Methods that cannot return an error, such as
+// [Schema.Versions], see an empty schema in that case.
+func NewLazySchemaFromFS(fsys fs.FS, name string) *Schema {
+	s := NewSchema("")
+	s.lazy = &lazySchema{fsys: fsys, name: name}
+	return s
+}
+
+// load reads the schema string if the Schema was made with
+// [NewLazySchemaFromFS] and it has not been read yet. It returns the error
+// from reading it, if any.
+func (s *Schema) load() error {
+	if s.lazy == nil {
+		return nil
+	}
+	s.lazy.once.Do(func() {
+		b, err := fs.ReadFile(s.lazy.fsys, s.lazy.name)
+		if err != nil {
+			s.lazy.err = fmt.Errorf("cannot read schema file: %w", err)
+			return
+		}
+		s.schema = string(b)
+	})
+	return s.lazy.err
+}
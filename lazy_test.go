@@ -0,0 +1,62 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// countingFS is an fs.FS that counts how many times files are opened.
+type countingFS struct {
+	fs.FS
+	opens int
+}
+
+func (f *countingFS) Open(name string) (fs.File, error) {
+	f.opens++
+	return f.FS.Open(name)
+}
+
+func TestNewLazySchemaFromFS(t *testing.T) {
+	fsys := &countingFS{FS: fstest.MapFS{
+		"schema.sql": {Data: []byte("CREATE TABLE a (id INTEGER);\n" + Delimiter + "\nCREATE TABLE b (id INTEGER);")},
+	}}
+
+	s := NewLazySchemaFromFS(fsys, "schema.sql")
+	if fsys.opens != 0 {
+		t.Fatalf("schema file was opened %d times before use, want 0", fsys.opens)
+	}
+
+	f, db := newFakeDB(t, 0)
+	if err := s.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+	if v := f.results["PRAGMA user_version"][0][0]; v != int64(2) {
+		t.Errorf("database is at version %v, want 2", v)
+	}
+
+	want := []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+
+	if fsys.opens != 1 {
+		t.Errorf("schema file was opened %d times, want 1", fsys.opens)
+	}
+}
+
+func TestNewLazySchemaFromFSMissing(t *testing.T) {
+	s := NewLazySchemaFromFS(fstest.MapFS{}, "schema.sql")
+
+	_, db := newFakeDB(t, 0)
+	if err := s.Migrate(context.Background(), db); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Migrate() = %v, want an error wrapping fs.ErrNotExist", err)
+	}
+
+	if schema := s.String(); schema != "" {
+		t.Errorf("String() = %q, want an empty schema", schema)
+	}
+}
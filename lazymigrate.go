@@ -3,83 +3,1727 @@
 package lazymigrate
 
 import (
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"strings"
+	"time"
 )
 
 // Delimiter is the default delimiter for the schema string.
 // It is intentionally long and ugly to avoid collisions.
 const Delimiter = "--------------------------------- NEW VERSION ---------------------------------"
 
+var (
+	// ErrVersionAhead is returned when the database's user_version is higher
+	// than the number of versions in the schema and [Schema.OnAhead] or
+	// [Schema.Strict] asks for an error. This usually means that the database
+	// was migrated by a newer version of the program.
+	ErrVersionAhead = errors.New("database version is ahead of schema")
+	// ErrDatabaseAhead is returned by [Schema.Migrate] when the database is
+	// ahead of the schema. It is the same error as [ErrVersionAhead].
+	ErrDatabaseAhead = ErrVersionAhead
+	// ErrInvalidVersion is returned in strict mode when the version of the
+	// database does not correspond to any version of the schema, such as when
+	// another tool has written a negative value to user_version.
+	ErrInvalidVersion = errors.New("invalid database version")
+	// ErrEmptyVersion is returned when a version of the schema is empty or
+	// only contains whitespace, which usually means that two magic comments
+	// were placed back-to-back or that the schema ends with one.
+	ErrEmptyVersion = errors.New("version is empty")
+	// ErrDuplicateVersion is returned by [Schema.Validate] when two versions
+	// of the schema are identical, which usually means that a version was
+	// pasted twice.
+	ErrDuplicateVersion = errors.New("version is a duplicate")
+	// ErrUnterminated is returned when the schema ends within a block
+	// comment, string literal or quoted identifier. Magic comments after its
+	// start are then not delimiters, so the schema is not split where it was
+	// meant to be.
+	ErrUnterminated = errors.New("schema ends within a comment or quote")
+)
+
+// MigrationError is returned when a statement in a version of the schema fails
+// to apply. Use [errors.As] to get it.
+type MigrationError struct {
+	// Index is the index of the failing version in [Schema.Versions].
+	Index int
+	// SQL is the statement that failed.
+	SQL string
+	// Offset is the byte offset of the statement within the version.
+	Offset int
+	// Line is the 1-based line of the schema string on which the failing
+	// version starts.
+	Line int
+	// Err is the error returned by the database.
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf(
+		"cannot apply migration %d (from 0th) at schema line %d, statement at offset %d %q: %v",
+		e.Index, e.Line, e.Offset, e.SQL, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
 // Schema wraps a SQLite schema string. A schema string is a series of SQL
 // statements that create and modify tables. The schema string is delimited by
 // a configurable magic comment. The magic comment must be on its own line
 // before and after the schema string. It must not appear at the start or end
 // of the schema string.
 type Schema struct {
+	// BeforeVersion, if not nil, is called just before each version is
+	// applied. If it returns an error, the migration is aborted and rolled
+	// back.
+	BeforeVersion Hook
+	// AfterVersion, if not nil, is called just after each version is applied.
+	// If it returns an error, the migration is aborted and rolled back.
+	AfterVersion Hook
+	// VerifyChecksums, if true, records a checksum of each version as it is
+	// applied, and makes migrating fail with [ErrChecksumMismatch] if a
+	// version that was already applied has since been changed. The version
+	// store must implement [ChecksumStore], such as [TableStore].
+	VerifyChecksums bool
+	// VerifyChain, if true, records a hash chain of the applied versions in
+	// the lazymigrate_chain table, where each hash covers a version and all
+	// versions before it. Migrating then fails with [ErrVersionsReordered],
+	// naming the first version that diverged, if a version was inserted,
+	// removed or moved before the current version of the database. It only
+	// works with SQLite.
+	VerifyChain bool
+	// Logger, if not nil, is used to log the progress of migrations.
+	Logger Logger
+	// Strict, if true, makes any database version that does not correspond to
+	// a version of the schema an error. Negative versions fail with
+	// [ErrInvalidVersion], and [Schema.Status] also fails for versions that
+	// are ahead of the schema instead of reporting them. This catches other
+	// tools writing to the same pragma early.
+	Strict bool
+	// OnAhead decides what happens when the database is ahead of the schema.
+	// The default of [OnAheadIgnore] leaves it as-is. If Strict is set, it
+	// always fails.
+	OnAhead AheadPolicy
+	// CheckForeignKeys, if true, runs PRAGMA foreign_key_check after the
+	// migrations are applied but before they are committed. If it reports any
+	// violations, the migration fails with a [ForeignKeyError] and is rolled
+	// back.
+	CheckForeignKeys bool
+	// CheckIntegrity, if true, runs PRAGMA integrity_check after the
+	// migrations are applied but before they are committed. If it reports
+	// anything other than "ok", the migration fails with an [IntegrityError]
+	// and is rolled back. It can be slow on large databases.
+	CheckIntegrity bool
+	// ManageVersion, if true, writes the new version to the version store
+	// after each version is applied. It is true for Schemas returned by the
+	// constructors in this package.
+	//
+	// If it is false, the version store is still read to decide which
+	// versions to apply, but it is never written to. The caller is then
+	// responsible for setting the version, or the same versions will be
+	// applied again on the next migration. Checksums are not recorded either.
+	ManageVersion bool
+	// GetVersion and SetVersion, if not nil, replace the Get and Set methods
+	// of the version store, such as to keep the versions of several tenants
+	// in the same database apart. They are called within the migration's
+	// transaction where there is one. The version store is still used for
+	// everything else, such as locking and checksums.
+	GetVersion func(ctx context.Context, q DBTX) (int, error)
+	SetVersion func(ctx context.Context, q DBTX, v int) error
+	// NoTxStatements lists the statements that do not work within a
+	// transaction, such as "VACUUM" or "PRAGMA journal_mode". Before applying
+	// pending versions within a transaction, they are scanned for statements
+	// starting with any of these words, and an error wrapping
+	// [ErrNotTransactional] is returned if one is found. If nil,
+	// [DefaultNoTxStatements] is used. Set it to an empty slice to disable the
+	// scan. [Schema.MigrateNoTx] does not scan.
+	NoTxStatements []string
+	// BusyRetries is the number of times a migration transaction is retried
+	// from the start if it fails because the database is busy or locked by
+	// another connection, which can happen on startup even with a
+	// busy_timeout when many processes migrate at once. The default of 0
+	// disables retrying. Hooks are called again for every retry.
+	BusyRetries int
+	// BusyBackoff is the delay before the first retry of a busy migration.
+	// It doubles after each retry. The default of 0 retries immediately.
+	BusyBackoff time.Duration
+	// Tracer, if not nil, is used to start a span around each version that is
+	// applied.
+	Tracer Tracer
+	// PerVersionTimeout, if not zero, limits how long applying a single
+	// version may take. If a version takes longer, its statements are
+	// cancelled and the migration fails with an error that wraps
+	// [context.DeadlineExceeded] and is rolled back.
+	PerVersionTimeout time.Duration
+	// NumberedVersions, if true, identifies versions by explicit numbers
+	// instead of their position in the schema. Each version must then start
+	// with a "-- version N" comment, as described in [Schema.VersionNumbers],
+	// and the version store holds the number of the last applied version.
+	// Versions whose number is greater than the stored one are pending, so
+	// removing or squashing an already applied version does not make other
+	// versions run again or get skipped.
+	//
+	// Version arguments and results of the methods, such as for
+	// [Schema.MigrateTo] and [Schema.CurrentVersion], still count versions
+	// from the start of the schema. It cannot be combined with
+	// VerifyChecksums.
+	NumberedVersions bool
+	// Rewrite, if not nil, is called with each version just before it is
+	// applied, and the returned SQL is applied instead. It is useful for
+	// substitutions such as table name prefixes. It is also called before
+	// the down section of a version is applied. Checksums and labels are
+	// computed from the version before it is rewritten. If it returns an
+	// error, the migration is aborted and rolled back.
+	Rewrite func(index int, sql string) (string, error)
+	// PreStatements are executed in order on the migration's connection
+	// before its transaction begins, such as ATTACH DATABASE statements that
+	// cannot run within a transaction. The versions are then applied on the
+	// same connection, so they can refer to the attached databases.
+	//
+	// The connection is returned to the pool of the *sql.DB afterwards with
+	// the effects of the statements still in place, so they may run again on
+	// the same connection by a later migration. ATTACH fails if the schema
+	// name is already in use, so it is safest to use a fresh *sql.DB.
+	PreStatements []string
+	// AfterCommit, if not nil, is called with the number of versions applied
+	// after the migration transaction is committed successfully. It is not
+	// called if the commit fails or if no versions were applied. It is meant
+	// for side effects that must only happen once the migrations are
+	// durable, such as invalidating caches. It is called by [Schema.Migrate]
+	// and its variants that use a single transaction, including
+	// [Schema.MigrateFrom], and after each commit of [Schema.Step] and
+	// [Schema.MigrateEachCommit]. [Schema.MigrateIncremental] calls it with
+	// the versions that were committed, even if a later version failed.
+	AfterCommit func(applied int)
+	// WrapEach, if not nil, is called with each version before it is applied.
+	// The returned before and after SQL, if not empty, are executed just
+	// before and after the version's statements, within the same
+	// transaction, such as to set and reset the search_path of a PostgreSQL
+	// tenant. The version itself is not changed. If either fails, the
+	// migration is aborted and rolled back.
+	WrapEach func(index int, sql string) (before, after string)
+	// Guard, if not nil, is called with each pending version before it is
+	// applied. If it returns false, the version is skipped, such as for a
+	// feature that is not enabled yet, but it still counts as applied: the
+	// database version still advances past it, and it is not applied by
+	// later migrations even once Guard would return true. Skipped versions
+	// must therefore not be depended on by later versions. If Guard returns
+	// an error, the migration is aborted and rolled back.
+	Guard func(index int, sql string) (apply bool, err error)
+	// Adopt, if true, makes [Schema.Migrate], [Schema.MigrateTo],
+	// [Schema.MigrateN] and [Schema.MigrateWithOptions] adopt an existing
+	// SQLite database that has tables but no version, such as one created
+	// before lazymigrate was used. Instead of applying every version again,
+	// which would fail on the existing tables, the database is marked as
+	// migrated to the target version. Methods built on them, such as
+	// [Schema.MustMigrate] and [Schema.MigrateToLabel], adopt as well. The
+	// other ways of migrating, such as [Schema.Step],
+	// [Schema.MigrateEachCommit], [Schema.MigrateIncremental],
+	// [Schema.MigrateNoTx], [Schema.MigrateFrom] and [Schema.DryRun], ignore
+	// Adopt.
+	//
+	// This is only done if the live schema of the database matches the one
+	// that migrating a fresh in-memory database to the target version
+	// produces exactly, apart from the normalization described in
+	// [Schema.CheckDrift]. Otherwise, migrating fails with an error wrapping
+	// [ErrAdoptMismatch]. The in-memory database is opened with the driver of
+	// the *sql.DB being migrated, and neither the hooks nor the PreStatements
+	// are run on it, since the PreStatements may attach real databases that
+	// the versions would then change. A database whose versions depend on
+	// the PreStatements, such as by creating tables in an attached database,
+	// therefore cannot be adopted, and migrating it fails instead.
+	Adopt bool
+
 	schema string
 	magic  string
+	// altMagics are other magic comments that also delimit versions.
+	altMagics []string
+	// match, if not nil, decides which lines delimit versions instead of the
+	// magic comments.
+	match func(line string) bool
+	store VersionStore
+	// lazy, if not nil, reads the schema string on first use.
+	lazy *lazySchema
+	// resumeIndex, if not zero, is one more than the index of the version
+	// that is resumed by ResumeNoTx.
+	resumeIndex int
+}
+
+// Hook is a function called around each version of the schema while migrating.
+// The index is the index of the version in [Schema.Versions], and sql is the
+// version's SQL. Hooks run inside the same transaction as the migration.
+type Hook func(ctx context.Context, index int, sql string) error
+
+// Logger is the interface used by [Schema] to log migration progress. It is
+// satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...any)
 }
 
-// NewSchema returns a new Schema with the given schema string. The schema
-// string is delimited by the default magic comment [Delimiter].
-func NewSchema(schema string) *Schema {
-	return NewSchemaWithMagic(schema, Delimiter)
+func (s *Schema) logf(format string, v ...any) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// NewSchema returns a new Schema with the given schema string. By default, the
+// schema string is delimited by the magic comment [Delimiter], and the version
+// is tracked in user_version. This and the other fields of the Schema can be
+// changed by the given options, such as:
+//
+//	lazymigrate.NewSchema(schema,
+//		lazymigrate.WithMagic("-- migrate"),
+//		lazymigrate.WithLogger(log.Default()),
+//		lazymigrate.WithPerVersionTimeout(time.Minute),
+//	)
+//
+// The other NewSchema functions are shorthands for common options.
+func NewSchema(schema string, opts ...Option) *Schema {
+	s := &Schema{
+		ManageVersion: true,
+		schema:        schema,
+		magic:         Delimiter,
+		store:         PragmaStore{"user_version"},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // NewSchemaWithMagic returns a new Schema with the given schema string and
-// magic comment.
+// magic comment. The magic comment must be valid as described in
+// [ValidateMagic], or NewSchemaWithMagic panics. Use
+// [NewSchemaWithMagicChecked] for a magic comment that is not known to be
+// valid.
 func NewSchemaWithMagic(schema, magic string) *Schema {
-	return &Schema{
-		schema: schema,
-		magic:  magic,
+	return NewSchema(schema, WithMagic(magic))
+}
+
+// NewSchemaWithMagicChecked is like [NewSchemaWithMagic], but it returns an
+// error instead of panicking if the magic comment is invalid.
+func NewSchemaWithMagicChecked(schema, magic string) (*Schema, error) {
+	if err := ValidateMagic(magic); err != nil {
+		return nil, err
+	}
+	return NewSchemaWithMagic(schema, magic), nil
+}
+
+// ValidateMagic checks that magic can be used as a magic comment. Since the
+// magic comment is matched against whole lines with surrounding whitespace
+// removed, it must not be empty or only whitespace, must not contain line
+// breaks, and must not start or end with whitespace.
+func ValidateMagic(magic string) error {
+	switch {
+	case strings.TrimSpace(magic) == "":
+		return errors.New("magic comment must not be empty")
+	case strings.ContainsAny(magic, "\r\n"):
+		return fmt.Errorf("magic comment %q must not contain line breaks", magic)
+	case strings.TrimSpace(magic) != magic:
+		return fmt.Errorf("magic comment %q must not start or end with whitespace", magic)
+	}
+	return nil
+}
+
+func mustValidateMagic(magic string) {
+	if err := ValidateMagic(magic); err != nil {
+		panic("lazymigrate: " + err.Error())
+	}
+}
+
+// NewSchemaWithMagics is like [NewSchemaWithMagic], but versions are delimited
+// by any of the given magic comments. This is useful while moving a schema
+// from one magic comment to another. The first magic comment is the one
+// returned by [Schema.Magic]. If no magic comments are given, [Delimiter] is
+// used.
+func NewSchemaWithMagics(schema string, magics ...string) *Schema {
+	return NewSchema(schema, WithMagics(magics...))
+}
+
+// NewSchemaWithMatcher returns a new Schema with the given schema string,
+// where versions are delimited by every line for which match returns true,
+// instead of by a magic comment. match is called with each line without its
+// line ending, including any surrounding whitespace. Lines within block
+// comments and multi-line string literals are not passed to it. [Schema.Magic]
+// returns an empty string for the returned Schema. It panics if match is nil.
+//
+// For example, to split on any line comment starting with "-- +migrate":
+//
+//	lazymigrate.NewSchemaWithMatcher(schema, func(line string) bool {
+//		return strings.HasPrefix(strings.TrimSpace(line), "-- +migrate")
+//	})
+func NewSchemaWithMatcher(schema string, match func(line string) bool) *Schema {
+	return NewSchema(schema, WithMatcher(match))
+}
+
+// NewSchemaWithPragma returns a new Schema with the given schema string and
+// magic comment that tracks its version in the given pragma instead of
+// user_version. This is useful when user_version is already used by something
+// else. The pragma name is not escaped, so it must be a trusted identifier such
+// as "application_id".
+func NewSchemaWithPragma(schema, magic, pragmaName string) *Schema {
+	return NewSchema(schema, WithMagic(magic), WithStore(PragmaStore{pragmaName}))
+}
+
+// NewSchemaWithTable returns a new Schema with the given schema string that
+// tracks its version in a table with the given name instead of a pragma. The
+// schema string is delimited by the default magic comment [Delimiter].
+//
+// The table is created if it does not exist, and a row with the version and
+// the time it was applied is inserted for every version applied, all within
+// the migration's transaction. This gives an audit trail of when each version
+// was applied. The current version is the one in the most recent row.
+func NewSchemaWithTable(schema, tableName string) *Schema {
+	return NewSchema(schema, WithStore(TableStore{Table: tableName}))
+}
+
+// NewSchemaWithStore returns a new Schema with the given schema string and
+// magic comment that tracks its version using the given [VersionStore]. Use
+// this with [PostgresStore] to migrate a PostgreSQL database. It panics if the
+// magic comment is invalid, as described in [ValidateMagic].
+func NewSchemaWithStore(schema, magic string, store VersionStore) *Schema {
+	return NewSchema(schema, WithMagic(magic), WithStore(store))
+}
+
+// NewSchemaFromFS returns a new Schema with the schema string read from the
+// file with the given name in fsys. The schema string is delimited by the
+// default magic comment [Delimiter]. It is meant to be used with embed.FS.
+func NewSchemaFromFS(fsys fs.FS, name string) (*Schema, error) {
+	return NewSchemaFromFSWithMagic(fsys, name, Delimiter)
+}
+
+// NewSchemaFromFSWithMagic is like [NewSchemaFromFS], but it uses the given
+// magic comment.
+func NewSchemaFromFSWithMagic(fsys fs.FS, name, magic string) (*Schema, error) {
+	if err := ValidateMagic(magic); err != nil {
+		return nil, err
 	}
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema file: %w", err)
+	}
+	return NewSchemaWithMagic(string(b), magic), nil
+}
+
+// NewSchemaFromFSGzip is like [NewSchemaFromFS], but the file is
+// gzip-compressed, such as schema.sql.gz, and is decompressed before use.
+func NewSchemaFromFSGzip(fsys fs.FS, name string) (*Schema, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema file: %w", err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress schema file: %w", err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decompress schema file: %w", err)
+	}
+	return NewSchema(string(b)), nil
 }
 
-// Versions returns the versions of the schema.
+// NewSchemaFromReader returns a new Schema with the schema string read from r
+// until EOF. The schema string is delimited by the default magic comment
+// [Delimiter].
+func NewSchemaFromReader(r io.Reader) (*Schema, error) {
+	return NewSchemaFromReaderWithMagic(r, Delimiter)
+}
+
+// NewSchemaFromReaderWithMagic is like [NewSchemaFromReader], but it uses the
+// given magic comment.
+func NewSchemaFromReaderWithMagic(r io.Reader, magic string) (*Schema, error) {
+	if err := ValidateMagic(magic); err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema: %w", err)
+	}
+	return NewSchemaWithMagic(string(b), magic), nil
+}
+
+// Magic returns the magic comment that delimits the versions of the schema.
+// For a Schema made with [NewSchemaWithMagics], it is the first magic comment.
+func (s *Schema) Magic() string {
+	return s.magic
+}
+
+// String returns the schema string as it was given, which is useful for
+// logging or computing a checksum of it.
+func (s *Schema) String() string {
+	s.load()
+	return s.schema
+}
+
+// Versions returns the versions of the schema. The schema string is split on
+// every line that consists of the magic comment. Leading and trailing
+// whitespace on that line is ignored, and both \n and \r\n line endings are
+// recognized. A magic comment line within a /* */ block comment or a
+// multi-line string literal or quoted identifier is not a delimiter, and
+// [Schema.Validate] reports one that is never closed. A PostgreSQL
+// dollar-quoted string cannot contain a magic comment line. A UTF-8 byte order
+// mark at the start of the schema string is dropped, and the last version does
+// not need to end with a line ending. The text of each version is otherwise
+// kept as-is.
+//
+// The schema string is scanned once, and each version is a substring of it
+// rather than a copy, so this is cheap even for very large schemas. Use
+// [Schema.EachVersion] to avoid allocating the slice as well.
 func (s *Schema) Versions() []string {
-	return strings.Split(s.schema, "\n"+s.magic+"\n")
+	var versions []string
+	s.eachSpan(func(start, end int) bool {
+		versions = append(versions, s.schema[start:end])
+		return true
+	})
+	return versions
+}
+
+// VersionCount returns the number of versions in the schema. It is equivalent
+// to len(s.Versions()) but does not allocate.
+func (s *Schema) VersionCount() int {
+	var n int
+	s.eachSpan(func(start, end int) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// VersionSpan is the location of a version within the schema string, as byte
+// offsets. The version is String()[Start:End].
+type VersionSpan struct {
+	Start, End int
+}
+
+// versionLine returns the 1-based line of the schema string on which the
+// version at index i starts.
+func (s *Schema) versionLine(i int) int {
+	line := 0
+	s.eachSpan(func(start, end int) bool {
+		if i == 0 {
+			line = 1 + strings.Count(s.schema[:start], "\n")
+			return false
+		}
+		i--
+		return true
+	})
+	return line
+}
+
+// VersionSpans returns the location of each version within the schema string,
+// in the same order as [Schema.Versions]. It is meant for tooling that needs
+// to map a position within a version back to the schema file.
+func (s *Schema) VersionSpans() []VersionSpan {
+	var spans []VersionSpan
+	s.eachSpan(func(start, end int) bool {
+		spans = append(spans, VersionSpan{Start: start, End: end})
+		return true
+	})
+	return spans
+}
+
+// EachVersion calls fn with each version of the schema and its index, in
+// order, without splitting the whole schema string up front. If fn returns an
+// error, the iteration stops and that error is returned.
+func (s *Schema) EachVersion(fn func(index int, sql string) error) error {
+	var i int
+	var err error
+	s.eachSpan(func(start, end int) bool {
+		err = fn(i, s.schema[start:end])
+		i++
+		return err == nil
+	})
+	return err
+}
+
+// eachSpan calls fn with the byte offsets of each version in the schema
+// string, in order. The magic comment line and the line ending just before it
+// are not part of any version. Magic comment lines within a block comment or a
+// multi-line string literal or quoted identifier do not count. The iteration
+// stops if fn returns false.
+//
+// If the schema string ends within a comment or literal, its state and the
+// line number where it started are returned, since any magic comment lines
+// after that point were not taken as delimiters.
+func (s *Schema) eachSpan(fn func(start, end int) bool) (state lineState, stateLine int) {
+	s.load()
+
+	// Editors on Windows may save the file with a UTF-8 byte order mark,
+	// which would stop a magic comment on the first line from matching.
+	start := 0
+	if strings.HasPrefix(s.schema, "\uFEFF") {
+		start = len("\uFEFF")
+	}
+
+	// closesAfter reports whether tag appears in the lines from offset i up
+	// to the next magic comment line.
+	closesAfter := func(i int, tag string) bool {
+		for i < len(s.schema) {
+			end := len(s.schema)
+			if j := strings.IndexByte(s.schema[i:], '\n'); j != -1 {
+				end = i + j
+			}
+			line := s.schema[i:end]
+			if s.isMagic(line) {
+				return false
+			}
+			if strings.Contains(line, tag) {
+				return true
+			}
+			i = end + 1
+		}
+		return false
+	}
+
+	for i, n := start, 1; i < len(s.schema); n++ {
+		end := len(s.schema)
+		next := len(s.schema)
+		if j := strings.IndexByte(s.schema[i:], '\n'); j != -1 {
+			end = i + j
+			next = end + 1
+		}
+
+		line := s.schema[i:end]
+		if state == "" && s.isMagic(line) {
+			// Exclude the line ending of the line before the magic comment,
+			// which may be either \n or \r\n.
+			prev := i
+			if prev > start && s.schema[prev-1] == '\n' {
+				prev--
+				if prev > start && s.schema[prev-1] == '\r' {
+					prev--
+				}
+			}
+			if !fn(start, prev) {
+				return "", 0
+			}
+			start = next
+			i = next
+			continue
+		}
+
+		prevState := state
+		state = scanLine(line, state, func(tag string) bool {
+			return closesAfter(next, tag)
+		})
+		if state != prevState {
+			stateLine = n
+		}
+
+		i = next
+	}
+	fn(start, len(s.schema))
+
+	if state == "" {
+		return "", 0
+	}
+	return state, stateLine
+}
+
+// endState returns the state at the end of the schema string and the line
+// where it started, as described in eachSpan.
+func (s *Schema) endState() (state lineState, stateLine int) {
+	return s.eachSpan(func(start, end int) bool { return true })
+}
+
+// checkTerminated returns an error wrapping [ErrUnterminated] if the schema
+// ends within a block comment, string literal or quoted identifier.
+func (s *Schema) checkTerminated() error {
+	if state, line := s.endState(); state != "" {
+		return fmt.Errorf("cannot find delimiters after the %s started on line %d: %w", state.describe(), line, ErrUnterminated)
+	}
+	return nil
+}
+
+func (s *Schema) isMagic(line string) bool {
+	if s.match != nil {
+		return s.match(strings.TrimSuffix(line, "\r"))
+	}
+
+	line = strings.TrimSpace(line)
+	if line == s.magic {
+		return true
+	}
+	for _, magic := range s.altMagics {
+		if line == magic {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the schema string is well-formed. It returns an error
+// if the schema starts or ends with the magic comment, if any version is
+// empty, such as when two magic comments are placed back-to-back, or if two
+// versions are identical apart from surrounding whitespace. It also returns an
+// error wrapping [ErrUnterminated] if the schema ends within a block comment,
+// string literal or quoted identifier, since any magic comments after its
+// start were then not taken as delimiters. Migrating such a schema fails with
+// the same error.
+func (s *Schema) Validate() error {
+	if err := s.checkTerminated(); err != nil {
+		return err
+	}
+
+	versions := s.Versions()
+	for i, version := range versions {
+		if strings.TrimSpace(version) != "" {
+			continue
+		}
+
+		switch {
+		case len(versions) == 1:
+			return errors.New("schema is empty")
+		case i == 0:
+			return errors.New("schema must not start with the magic comment")
+		case i == len(versions)-1:
+			return errors.New("schema must not end with the magic comment")
+		default:
+			return fmt.Errorf("version %d (from 0th): %w", i, ErrEmptyVersion)
+		}
+	}
+
+	seen := make(map[string]int, len(versions))
+	for i, version := range versions {
+		sum := checksum(strings.TrimSpace(version))
+		if j, ok := seen[sum]; ok {
+			return fmt.Errorf("version %d (from 0th) is the same as version %d: %w", i, j, ErrDuplicateVersion)
+		}
+		seen[sum] = i
+	}
+
+	return nil
 }
 
 // Migrate migrates the database at the given source to the latest migrations.
-// It uses the user_version pragma. Note that the function does not set any
-// pragma values except for user_version. If you need to set other pragmas,
-// you must do so yourself.
+// It tracks the version using the user_version pragma, unless the Schema was
+// created with a different [VersionStore], such as with [NewSchemaWithPragma]
+// or [NewSchemaWithTable]. Note that the function does not set any pragma
+// values except for user_version. If you need to set other pragmas, you must do
+// so yourself.
+//
+// The migrations are all done in a single transaction. For SQLite, it is
+// started with BEGIN IMMEDIATE so that concurrent migrations of the same
+// database wait for each other, given a busy_timeout. If any migration fails,
+// the transaction is rolled back and the error is returned. If the database is
+// already up to date, nothing is done. If the database is ahead of the schema,
+// nothing is done either, unless [Schema.OnAhead] or [Schema.Strict] asks for
+// an error wrapping [ErrDatabaseAhead]. If a pending version is empty, an
+// error wrapping [ErrEmptyVersion] is returned.
 //
-// The migrations are all done in a single transaction. If any migration fails,
-// the transaction is rolled back and the error is returned.
+// Each statement is executed with ExecContext without any arguments. For
+// drivers that implement [database/sql/driver.ExecerContext], such as
+// mattn/go-sqlite3 and modernc.org/sqlite, database/sql then runs the
+// statement directly instead of preparing it, so no prepared statement is
+// cached across the schema changes made by the migrations.
 func (s *Schema) Migrate(ctx context.Context, db *sql.DB) error {
-	tx, err := db.Begin()
+	return s.MigrateTo(ctx, db, s.VersionCount())
+}
+
+// MigrateDB is like [Schema.Migrate], but it uses [context.Background]. It is
+// meant for code that does not pass contexts around yet; prefer Migrate
+// otherwise.
+func (s *Schema) MigrateDB(db *sql.DB) error {
+	return s.Migrate(context.Background(), db)
+}
+
+// MustMigrate is like [Schema.Migrate], but it panics if migrating fails. It is
+// meant for small programs and tests where a failed migration is fatal.
+func (s *Schema) MustMigrate(ctx context.Context, db *sql.DB) {
+	if err := s.Migrate(ctx, db); err != nil {
+		panic("lazymigrate: " + err.Error())
+	}
+}
+
+// MigrateTo is like [Schema.Migrate], but it only migrates the database up to
+// the given target version. The target version is the value that user_version
+// will have after migrating, which is the number of versions applied. A target
+// larger than len(Versions()) is clamped to it.
+//
+// Since there are no down migrations, an error is returned if the target is
+// lower than the current user_version.
+func (s *Schema) MigrateTo(ctx context.Context, db *sql.DB, target int) error {
+	_, err := s.migrateDB(ctx, db, target, nil)
+	return err
+}
+
+// MigrateN is like [Schema.Migrate], but it also returns the number of
+// versions that were applied. It returns 0 if the database is already up to
+// date.
+func (s *Schema) MigrateN(ctx context.Context, db *sql.DB) (applied int, err error) {
+	return s.migrateDB(ctx, db, s.VersionCount(), nil)
+}
+
+func (s *Schema) migrateDB(ctx context.Context, db *sql.DB, target int, opts *sql.TxOptions) (int, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	// Both adopting and migrating run on conn, so the PreStatements must only
+	// be run once for them.
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	if s.Adopt {
+		if err := s.adopt(ctx, db, conn, target); err != nil {
+			return 0, err
+		}
+	}
+
+	return s.migratePrepared(ctx, conn, target, opts)
+}
+
+// MigrateWithOptions is like [Schema.Migrate], but it begins the transaction
+// with the given options. If opts is nil, it behaves exactly like Migrate.
+// Otherwise, the options are passed to [sql.Conn.BeginTx] as-is, so SQLite
+// transactions are no longer started with BEGIN IMMEDIATE.
+func (s *Schema) MigrateWithOptions(ctx context.Context, db *sql.DB, opts *sql.TxOptions) error {
+	_, err := s.migrateDB(ctx, db, s.VersionCount(), opts)
+	return err
+}
+
+// MigrateConn is like [Schema.Migrate], but it migrates using the given
+// connection instead of one from the pool. This guarantees that reading
+// user_version and applying the migrations all happen on a connection that the
+// caller has already configured, e.g. with busy_timeout or foreign_keys.
+func (s *Schema) MigrateConn(ctx context.Context, conn *sql.Conn) error {
+	_, err := s.migrateConn(ctx, conn, s.VersionCount(), nil)
+	return err
+}
+
+// MigrateTx is like [Schema.Migrate], but it migrates within the given
+// transaction instead of its own. It neither begins nor commits the
+// transaction, so the caller can bundle the migrations with other work, such
+// as inserting seed data, and commit everything atomically.
+func (s *Schema) MigrateTx(ctx context.Context, tx *sql.Tx) error {
+	_, err := s.migrateTx(ctx, tx, s.VersionCount())
+	return err
+}
+
+// MigrateSavepoint is like [Schema.MigrateTx], but it migrates within a
+// savepoint with the given name in tx, so that it can nest inside a
+// transaction owned by another tool. The savepoint is released if the
+// migrations succeed, or rolled back to and then released if they fail, which
+// leaves tx as it was before and still usable. The name is quoted, so it may be
+// any non-empty identifier, but it should not clash with the caller's own
+// savepoints.
+func (s *Schema) MigrateSavepoint(ctx context.Context, tx *sql.Tx, name string) error {
+	if name == "" {
+		return errors.New("cannot migrate in savepoint: empty savepoint name")
+	}
+	name = quoteIdent(name)
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("cannot create savepoint %s: %w", name, err)
+	}
+
+	if _, err := s.migrateTx(ctx, tx, s.VersionCount()); err != nil {
+		// Use a new context, since the error may be from ctx being
+		// cancelled. Rolling back to a savepoint keeps it, so it must still
+		// be released afterwards.
+		if _, rerr := tx.ExecContext(context.Background(), "ROLLBACK TO "+name); rerr != nil {
+			return fmt.Errorf("cannot roll back to savepoint %s: %w", name, rerr)
+		}
+		if _, rerr := tx.ExecContext(context.Background(), "RELEASE "+name); rerr != nil {
+			return fmt.Errorf("cannot release savepoint %s: %w", name, rerr)
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "RELEASE "+name); err != nil {
+		return fmt.Errorf("cannot release savepoint %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (s *Schema) migrateConn(ctx context.Context, conn *sql.Conn, target int, opts *sql.TxOptions) (int, error) {
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return 0, err
+	}
+	return s.migratePrepared(ctx, conn, target, opts)
+}
+
+// migratePrepared is like migrateConn, but the PreStatements must already have
+// been executed on conn.
+func (s *Schema) migratePrepared(ctx context.Context, conn *sql.Conn, target int, opts *sql.TxOptions) (int, error) {
+	var applied int
+	err := s.retryTx(ctx, conn, opts, func(tx DBTX) error {
+		var err error
+		applied, err = s.migrateTx(ctx, tx, target)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.afterCommit(applied)
+	return applied, nil
+}
+
+// inTx runs the PreStatements on conn, then runs fn in a transaction on conn
+// and commits it if fn succeeds. The whole transaction is retried if the
+// database is busy, as configured by BusyRetries.
+func (s *Schema) inTx(ctx context.Context, conn *sql.Conn, opts *sql.TxOptions, fn func(tx DBTX) error) error {
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return err
+	}
+	return s.retryTx(ctx, conn, opts, fn)
+}
+
+// retryTx is like inTx, but it does not run the PreStatements.
+func (s *Schema) retryTx(ctx context.Context, conn *sql.Conn, opts *sql.TxOptions, fn func(tx DBTX) error) error {
+	return s.retryBusy(ctx, func() error {
+		return s.inTxOnce(ctx, conn, opts, fn)
+	})
+}
+
+func (s *Schema) afterCommit(applied int) {
+	if s.AfterCommit != nil && applied > 0 {
+		s.AfterCommit(applied)
+	}
+}
+
+// execPreStatements executes the PreStatements on conn.
+func (s *Schema) execPreStatements(ctx context.Context, conn *sql.Conn) error {
+	for _, stmt := range s.PreStatements {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("cannot execute pre-migration statement %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) inTxOnce(ctx context.Context, conn *sql.Conn, opts *sql.TxOptions, fn func(tx DBTX) error) error {
+	tx, err := s.begin(ctx, conn, opts)
 	if err != nil {
-		return fmt.Errorf("cannot begin transaction: %w", err)
+		return err
 	}
 	defer tx.Rollback()
 
-	var v int
-	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&v); err != nil {
-		return fmt.Errorf("cannot get PRAGMA user_version: %w", err)
+	if err := fn(tx); err != nil {
+		return err
 	}
 
-	versions := s.Versions()
-	if v >= len(versions) {
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit new migrations: %w", err)
+	}
+
+	return nil
+}
+
+// MigrateFrom is like [Schema.Migrate], but it trusts that the database is at
+// the given version instead of reading it, which saves a query when the
+// versions of many databases were already read in a batch. The caller asserts
+// that the version is accurate: versions before it are never applied, and
+// versions after it are applied even if they already were. The new version is
+// still recorded, and everything is still done in a single transaction.
+//
+// Since nothing is read from the database, some options of the Schema do not
+// apply:
+//
+//   - [Schema.VerifyChecksums] and [Schema.VerifyChain] are not verified.
+//     The checksums and hashes of the versions that are applied are still
+//     recorded.
+//   - [Schema.Adopt] is ignored, so an existing database without a version
+//     is not adopted.
+//   - With [Schema.NumberedVersions], version is the number of versions
+//     applied, counted by position, and not a number from a "-- version N"
+//     comment. The number of the last version is still what gets stored.
+func (s *Schema) MigrateFrom(ctx context.Context, db *sql.DB, version int) error {
+	if version < 0 {
+		return fmt.Errorf("cannot migrate from negative version %d", version)
+	}
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	// The version is not read, so this is not checked by currentVersion.
+	if err := s.checkTerminated(); err != nil {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var applied int
+	err = s.inTx(ctx, conn, nil, func(tx DBTX) error {
+		applied = 0
+
+		if err := s.lock(ctx, tx); err != nil {
+			return err
+		}
+
+		latest := s.VersionCount()
+		if err := s.checkVersion(version, latest); err != nil || version >= latest {
+			return err
+		}
+
+		if err := s.checkTransactional(version, latest); err != nil {
+			return err
+		}
+
+		if err := s.applyVersions(ctx, tx, version, latest); err != nil {
+			return err
+		}
+
+		applied = latest - version
 		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.afterCommit(applied)
+	return nil
+}
+
+// Step applies only the next pending version of the schema, if any, and
+// reports whether a version was applied. It is useful for careful rollouts
+// where the system is observed between each version.
+func (s *Schema) Step(ctx context.Context, db *sql.DB) (applied bool, err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return false, err
+	}
+
+	return s.step(ctx, conn)
+}
+
+// MigrateEachCommit is like [Schema.Migrate], but it applies and commits each
+// version in its own transaction before starting the next one. If a version
+// fails, its transaction is rolled back and the error is returned right away,
+// leaving the database at the last committed version.
+//
+// Unlike the default of a single transaction for all versions, a crash or
+// failure partway through leaves the database at some version between the
+// current and the latest one, but always at a consistent one that other
+// connections can already query. Unlike [Schema.MigrateIncremental], the
+// versions before a failure are durably committed as they go, instead of all
+// at the end.
+func (s *Schema) MigrateEachCommit(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return err
+	}
+
+	for {
+		applied, err := s.step(ctx, conn)
+		if err != nil || !applied {
+			return err
+		}
+	}
+}
+
+// step applies the next pending version, if any, in its own transaction on
+// conn.
+func (s *Schema) step(ctx context.Context, conn *sql.Conn) (applied bool, err error) {
+	err = s.retryBusy(ctx, func() error {
+		applied = false
+		return s.inTxOnce(ctx, conn, nil, func(tx DBTX) error {
+			v, latest, err := s.startVersion(ctx, tx)
+			if err != nil || v >= latest {
+				return err
+			}
+
+			if err := s.checkTransactional(v, v+1); err != nil {
+				return err
+			}
+
+			if err := s.applyVersions(ctx, tx, v, v+1); err != nil {
+				return err
+			}
+
+			applied = true
+			return nil
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if applied {
+		s.afterCommit(1)
+	}
+
+	return applied, nil
+}
+
+// DryRun runs the same statements as [Schema.Migrate], including the
+// user_version update, but always rolls the transaction back at the end. It is
+// useful for checking that the migrations apply cleanly without changing the
+// database.
+func (s *Schema) DryRun(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return err
 	}
 
-	for i := v; i < len(versions); i++ {
-		_, err := tx.ExecContext(ctx, versions[i])
+	tx, err := s.begin(ctx, conn, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.migrateTx(ctx, tx, s.VersionCount()); err != nil {
+		return err
+	}
+
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("cannot roll back dry run: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Schema) migrateTx(ctx context.Context, tx DBTX, target int) (int, error) {
+	v, latest, err := s.startVersion(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The database may only be ahead if the OnAhead policy allows it, in
+	// which case there is nothing to do.
+	if v > latest {
+		return 0, nil
+	}
+
+	if target > latest {
+		target = latest
+	}
+
+	if v > target {
+		return 0, fmt.Errorf("cannot migrate down to version %d from version %d", target, v)
+	}
+
+	if v == target {
+		return 0, nil
+	}
+
+	if err := s.checkTransactional(v, target); err != nil {
+		return 0, err
+	}
+
+	if err := s.applyVersions(ctx, tx, v, target); err != nil {
+		return 0, err
+	}
+
+	return target - v, nil
+}
+
+// startVersion takes the version store's lock if it has one, then returns the
+// current version of the database and the latest version of the schema. An
+// error is returned if the database is ahead of the schema and the OnAhead
+// policy does not allow it.
+func (s *Schema) startVersion(ctx context.Context, tx DBTX) (v, latest int, err error) {
+	if err := s.lock(ctx, tx); err != nil {
+		return 0, 0, err
+	}
+	return s.currentVersion(ctx, tx)
+}
+
+// lock takes the version store's lock if it has one.
+func (s *Schema) lock(ctx context.Context, tx DBTX) error {
+	if locker, ok := s.store.(VersionLocker); ok {
+		return locker.Lock(ctx, tx)
+	}
+	return nil
+}
+
+// currentVersion returns the current version of the database and the latest
+// version of the schema, checking that the database can be migrated from it.
+func (s *Schema) currentVersion(ctx context.Context, q DBTX) (v, latest int, err error) {
+	// The versions cannot be counted if the schema is not split where it was
+	// meant to be.
+	if err := s.checkTerminated(); err != nil {
+		return 0, 0, err
+	}
+
+	v, err = s.getVersion(ctx, q)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	latest = s.VersionCount()
+	if err := s.checkVersion(v, latest); err != nil {
+		return 0, 0, err
+	}
+
+	// A negative version can only be set by hand, and no version of the
+	// schema has been applied to it.
+	v = max(v, 0)
+
+	if s.VerifyChecksums {
+		if err := s.verifyChecksums(ctx, q, v); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if s.VerifyChain {
+		if err := s.verifyChain(ctx, q, v); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return v, latest, nil
+}
+
+// errStop is used to stop [Schema.EachVersion] early.
+var errStop = errors.New("stop")
+
+// applyVersions applies the versions from index from up to but not including
+// index to.
+func (s *Schema) applyVersions(ctx context.Context, q DBTX, from, to int) error {
+	numbers, err := s.numbers()
+	if err != nil {
+		return err
+	}
+
+	latest := s.VersionCount()
+	err = s.EachVersion(func(i int, version string) error {
+		if i < from {
+			return nil
+		}
+		if i >= to {
+			return errStop
+		}
+		s.logf("applying version %d/%d", i+1, latest)
+		return s.applyStep(ctx, q, i, version, numbers)
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+
+	if err := s.runChecks(ctx, q); err != nil {
+		return err
+	}
+
+	s.logf("done")
+	return nil
+}
+
+// applyStep applies the version at index i and updates the version store to
+// count it as applied. The numbers are the ones returned by numbers.
+func (s *Schema) applyStep(ctx context.Context, q DBTX, i int, version string, numbers []int) (err error) {
+	// Check for cancellation before starting a version, since a long-running
+	// statement may not notice it.
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cannot apply migration %d (from 0th): %w", i, err)
+	}
+
+	// Applying an empty version would silently count it as applied, which
+	// hides a missing migration. The down section of the version, if any, is
+	// only run by MigrateDownTo.
+	rewritten, err := s.rewrite(i, version)
+	if err != nil {
+		return err
+	}
+	up, _, _ := splitDown(rewritten)
+	if strings.TrimSpace(up) == "" {
+		return fmt.Errorf("cannot apply migration %d (from 0th): %w", i, ErrEmptyVersion)
+	}
+
+	if s.Tracer != nil {
+		var span TraceSpan
+		ctx, span = s.Tracer.StartSpan(ctx, versionSpanName, i)
+		defer func() {
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.End()
+		}()
+	}
+
+	apply := true
+	if s.Guard != nil {
+		apply, err = s.Guard(i, version)
 		if err != nil {
-			return fmt.Errorf("cannot apply migration %d (from 0th): %w", i, err)
+			return fmt.Errorf("Guard failed for migration %d (from 0th): %w", i, err)
 		}
 	}
 
-	if _, err := tx.ExecContext(ctx, fmt.Sprintln("PRAGMA user_version =", len(versions))); err != nil {
-		return fmt.Errorf("cannot set PRAGMA user_version: %w", err)
+	if apply {
+		if err := s.applyVersionTimeout(ctx, q, i, up); err != nil {
+			return err
+		}
+	} else {
+		s.logf("skipping version %d: guard returned false", i+1)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("cannot commit new migrations: %w", err)
+	if !s.ManageVersion {
+		return nil
+	}
+
+	if err := s.setVersion(ctx, q, i+1, numbers); err != nil {
+		return err
+	}
+
+	if s.VerifyChecksums {
+		if err := s.recordChecksum(ctx, q, i, version); err != nil {
+			return err
+		}
+	}
+
+	if s.VerifyChain {
+		if err := s.recordChain(ctx, q, i, version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateIncremental is like [Schema.Migrate], but it keeps the versions that
+// were applied successfully if a later version fails. Each version is applied
+// within its own savepoint in a single transaction. If a version fails, it is
+// rolled back to its savepoint and the transaction is committed, leaving the
+// database at the last fully applied version, and the error is returned.
+//
+// Unlike the all-or-nothing [Schema.Migrate], a failure can leave the database
+// at any version between the current and the latest one, so the program must
+// be able to cope with that. In exchange, fixing a failing version does not
+// require applying the versions before it again.
+func (s *Schema) MigrateIncremental(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var applied int
+	var applyErr error
+	err = s.inTx(ctx, conn, nil, func(tx DBTX) error {
+		v, _, err := s.startVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+		applied, applyErr, err = s.applySavepoints(ctx, tx, v)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The versions before a failing one are committed as well.
+	s.afterCommit(applied)
+	return applyErr
+}
+
+// applySavepoints applies the versions starting at index from, each within its
+// own savepoint, and returns the number of versions applied. If a version
+// fails, it is rolled back to its savepoint and its error is returned as
+// applyErr. The returned err is for errors that leave the transaction
+// unusable.
+func (s *Schema) applySavepoints(ctx context.Context, tx DBTX, from int) (applied int, applyErr, err error) {
+	latest := s.VersionCount()
+	if err := s.checkTransactional(from, latest); err != nil {
+		return 0, nil, err
+	}
+
+	numbers, err := s.numbers()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = s.EachVersion(func(i int, version string) error {
+		if i < from {
+			return nil
+		}
+
+		s.logf("applying version %d/%d", i+1, latest)
+
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT lazymigrate"); err != nil {
+			return fmt.Errorf("cannot create savepoint for migration %d (from 0th): %w", i, err)
+		}
+
+		if err := s.applyStep(ctx, tx, i, version, numbers); err != nil {
+			// Use a new context, since the error may be from ctx being
+			// cancelled.
+			if _, rerr := tx.ExecContext(context.Background(), "ROLLBACK TO lazymigrate"); rerr != nil {
+				return fmt.Errorf("cannot roll back migration %d (from 0th) to savepoint: %w", i, rerr)
+			}
+			applyErr = err
+			return errStop
+		}
+
+		if _, err := tx.ExecContext(ctx, "RELEASE lazymigrate"); err != nil {
+			return fmt.Errorf("cannot release savepoint for migration %d (from 0th): %w", i, err)
+		}
+
+		applied++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return 0, nil, err
+	}
+
+	// The versions that were applied are committed even if a later one
+	// failed, so they must pass the checks either way.
+	if err := s.runChecks(ctx, tx); err != nil {
+		return 0, nil, err
+	}
+
+	if applyErr == nil {
+		s.logf("done")
+	}
+	return applied, applyErr, nil
+}
+
+// MigrateNoTx is like [Schema.Migrate], but it does not wrap the migrations in
+// a transaction. This is needed for statements that SQLite refuses to run
+// inside a transaction, such as VACUUM, or pragmas like journal_mode and
+// foreign_keys that have no effect inside one.
+//
+// All versions are applied on a single connection, and user_version is updated
+// after each version that is applied successfully. If a version fails, the
+// database is left partially migrated: user_version reflects the last fully
+// applied version, but the statements of the failing version that came before
+// the error will have already taken effect.
+func (s *Schema) MigrateNoTx(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return err
+	}
+
+	v, latest, err := s.currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return s.applyVersions(ctx, conn, v, latest)
+}
+
+// Baseline marks the database as already being at the given version without
+// running any SQL. This is used to adopt an existing database that already has
+// the schema up to that version. It refuses to lower the version of a database
+// that is already at a higher version; use [Schema.ForceBaseline] for that.
+//
+// No checksums are recorded for the versions marked as applied.
+func (s *Schema) Baseline(ctx context.Context, db *sql.DB, version int) error {
+	return s.baseline(ctx, db, version, false)
+}
+
+// ForceBaseline is like [Schema.Baseline], but it also allows lowering the
+// version of the database.
+func (s *Schema) ForceBaseline(ctx context.Context, db *sql.DB, version int) error {
+	return s.baseline(ctx, db, version, true)
+}
+
+func (s *Schema) baseline(ctx context.Context, db *sql.DB, version int, force bool) error {
+	if latest := s.VersionCount(); version < 0 || version > latest {
+		return fmt.Errorf("cannot baseline to version %d: schema has versions 0 to %d", version, latest)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	return s.inTx(ctx, conn, nil, func(tx DBTX) error {
+		if err := s.lock(ctx, tx); err != nil {
+			return err
+		}
+
+		v, err := s.getVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if version < v && !force {
+			return fmt.Errorf("cannot baseline to version %d: database is already at version %d", version, v)
+		}
+
+		numbers, err := s.numbers()
+		if err != nil {
+			return err
+		}
+		return s.setVersion(ctx, tx, version, numbers)
+	})
+}
+
+// Reset sets the version of the database back to 0, so that the next
+// migration applies every version again. It is meant for tests that migrate
+// the same database repeatedly. It does not drop any tables or undo any
+// versions; that is up to the caller. It is the same as [Schema.ForceBaseline]
+// with version 0.
+func (s *Schema) Reset(ctx context.Context, db *sql.DB) error {
+	return s.ForceBaseline(ctx, db, 0)
+}
+
+// CurrentVersion returns the current user_version of the database. It does not
+// open a transaction. If the database is ahead of the schema and
+// [Schema.OnAhead] or [Schema.Strict] asks for an error, the version is
+// returned along with an error wrapping [ErrVersionAhead].
+func (s *Schema) CurrentVersion(ctx context.Context, db *sql.DB) (int, error) {
+	v, err := s.getVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.checkVersion(v, s.VersionCount()); err != nil {
+		return v, err
+	}
+
+	return v, nil
+}
+
+// NeedsMigration reports whether the database is behind the schema. It only
+// reads the version with a plain query, without a transaction or any locks, so
+// it is cheap enough for readiness probes. It never writes to the database.
+func (s *Schema) NeedsMigration(ctx context.Context, db *sql.DB) (bool, error) {
+	v, err := s.getVersion(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	return v < s.VersionCount(), nil
+}
+
+// Status returns the current version of the database and the latest version
+// of the schema. The database is behind by latest - current versions. Both
+// are read within a single read transaction.
+func (s *Schema) Status(ctx context.Context, db *sql.DB) (current, latest int, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err = s.getVersion(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	latest = s.VersionCount()
+	if s.Strict {
+		if err := s.checkVersion(current, latest); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return current, latest, nil
+}
+
+// Plan describes what [Schema.Migrate] would do to a database.
+type Plan struct {
+	// Current is the current version of the database.
+	Current int
+	// Latest is the latest version of the schema.
+	Latest int
+	// Pending holds the indices of the versions that have not been applied,
+	// in the order that they would be applied.
+	Pending []int
+}
+
+// Plan returns a [Plan] describing which versions are already applied to the
+// database and which are pending.
+func (s *Schema) Plan(ctx context.Context, db *sql.DB) (Plan, error) {
+	current, latest, err := s.Status(ctx, db)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{
+		Current: current,
+		Latest:  latest,
+	}
+	for i := max(current, 0); i < latest; i++ {
+		plan.Pending = append(plan.Pending, i)
+	}
+
+	return plan, nil
+}
+
+// PendingVersions returns the versions that have not yet been applied to the
+// database, in the order that [Schema.Migrate] would apply them. Nothing is
+// executed.
+func (s *Schema) PendingVersions(ctx context.Context, db *sql.DB) ([]string, error) {
+	v, err := s.CurrentVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version may be negative or ahead of the schema if Strict is not
+	// set.
+	versions := s.Versions()
+	return versions[min(max(v, 0), len(versions)):], nil
+}
+
+// NextVersion returns the index (from 0th) of the first version that has not
+// yet been applied to the database. If the database is up to date,
+// len(Versions()) is returned, which is the index that a new version appended
+// to the schema would have. Nothing is executed.
+func (s *Schema) NextVersion(ctx context.Context, db *sql.DB) (int, error) {
+	v, err := s.CurrentVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	// The version may be negative or ahead of the schema if Strict is not
+	// set.
+	return min(max(v, 0), s.VersionCount()), nil
+}
+
+// checkVersion checks that the database version v can be migrated to the
+// latest version of the schema.
+func (s *Schema) checkVersion(v, latest int) error {
+	if v > latest {
+		switch {
+		case s.Strict, s.OnAhead == OnAheadError:
+			return aheadError(v, latest)
+		case s.OnAhead == OnAheadWarn:
+			s.logf("warning: database is at version %d, schema only has %d", v, latest)
+		}
+		return nil
+	}
+	if v < 0 && s.Strict {
+		return fmt.Errorf("%w: %d is negative", ErrInvalidVersion, v)
+	}
+	return nil
+}
+
+func aheadError(v, latest int) error {
+	return fmt.Errorf("%w: database is at version %d, schema only has %d", ErrDatabaseAhead, v, latest)
+}
+
+// rewrite returns the version at index i as rewritten by Rewrite.
+func (s *Schema) rewrite(i int, version string) (string, error) {
+	if s.Rewrite == nil {
+		return version, nil
+	}
+	version, err := s.Rewrite(i, version)
+	if err != nil {
+		return "", fmt.Errorf("cannot rewrite migration %d (from 0th): %w", i, err)
+	}
+	return version, nil
+}
+
+// applyVersionTimeout is like applyVersion, but it applies the
+// PerVersionTimeout, if any.
+func (s *Schema) applyVersionTimeout(ctx context.Context, q DBTX, i int, version string) error {
+	if s.PerVersionTimeout <= 0 {
+		return s.applyVersion(ctx, q, i, version)
+	}
+
+	vctx, cancel := context.WithTimeout(ctx, s.PerVersionTimeout)
+	defer cancel()
+
+	err := s.applyVersion(vctx, q, i, version)
+	if err == nil || ctx.Err() != nil || !errors.Is(vctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	// Keep err, which may be a *MigrationError pointing at the statement that
+	// timed out. Some drivers report the interruption with an error of their
+	// own that does not wrap the context's error.
+	if !errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w: %w", err, context.DeadlineExceeded)
+	}
+	return fmt.Errorf("migration %d (from 0th) timed out after %v: %w", i, s.PerVersionTimeout, err)
+}
+
+// applyVersion applies a single version of the schema, running the hooks
+// around it. Each statement in the version is executed separately so that the
+// error can point at the statement that failed.
+func (s *Schema) applyVersion(ctx context.Context, q DBTX, i int, version string) error {
+	if s.BeforeVersion != nil {
+		if err := s.BeforeVersion(ctx, i, version); err != nil {
+			return fmt.Errorf("BeforeVersion hook failed for migration %d (from 0th): %w", i, err)
+		}
+	}
+
+	var before, after string
+	if s.WrapEach != nil {
+		before, after = s.WrapEach(i, version)
+	}
+
+	if before != "" {
+		if _, err := q.ExecContext(ctx, before); err != nil {
+			return fmt.Errorf("cannot run statement before migration %d (from 0th): %w", i, err)
+		}
+	}
+
+	if err := s.execStatements(ctx, q, i, version, 0); err != nil {
+		return err
+	}
+
+	if after != "" {
+		if _, err := q.ExecContext(ctx, after); err != nil {
+			return fmt.Errorf("cannot run statement after migration %d (from 0th): %w", i, err)
+		}
+	}
+
+	if s.AfterVersion != nil {
+		if err := s.AfterVersion(ctx, i, version); err != nil {
+			return fmt.Errorf("AfterVersion hook failed for migration %d (from 0th): %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// execStatements executes each statement of src, which is part of the version
+// at index i starting at the given byte offset, and returns a
+// [*MigrationError] for the statement that fails.
+func (s *Schema) execStatements(ctx context.Context, q DBTX, i int, src string, offset int) error {
+	stmts, err := splitStatements(src)
+	if err != nil {
+		return fmt.Errorf("cannot split migration %d (from 0th): %w", i, err)
+	}
+
+	for _, stmt := range stmts {
+		// Passing no arguments lets database/sql skip preparing the
+		// statement, which could otherwise be cached by the driver and fail
+		// with "schema has changed" after later DDL.
+		if _, err := q.ExecContext(ctx, stmt.sql); err != nil {
+			if i == s.resumeIndex-1 && isAlreadyExists(err) {
+				s.logf("skipping statement at offset %d of version %d: %v", offset+stmt.offset, i+1, err)
+				continue
+			}
+			return &MigrationError{
+				Index:  i,
+				SQL:    stmt.sql,
+				Offset: offset + stmt.offset,
+				Line:   s.versionLine(i),
+				Err:    err,
+			}
+		}
 	}
 
 	return nil
@@ -90,3 +1734,26 @@ func (s *Schema) Migrate(ctx context.Context, db *sql.DB) error {
 func Migrate(ctx context.Context, db *sql.DB, schema string) error {
 	return NewSchema(schema).Migrate(ctx, db)
 }
+
+// MigrateReader is like [Migrate], but it reads the schema string from r until
+// EOF. It is a convenience function around [NewSchemaFromReader] and
+// [Schema.Migrate].
+func MigrateReader(ctx context.Context, db *sql.DB, r io.Reader) error {
+	s, err := NewSchemaFromReader(r)
+	if err != nil {
+		return err
+	}
+	return s.Migrate(ctx, db)
+}
+
+// MigrateWithMagic is like [Migrate], but it uses the given magic comment. It
+// is a convenience function around [NewSchemaWithMagic] and [Schema.Migrate].
+func MigrateWithMagic(ctx context.Context, db *sql.DB, schema, magic string) error {
+	return NewSchemaWithMagic(schema, magic).Migrate(ctx, db)
+}
+
+// MustMigrate is like [Migrate], but it panics if migrating fails. It is a
+// convenience function around [NewSchema] and [Schema.MustMigrate].
+func MustMigrate(ctx context.Context, db *sql.DB, schema string) {
+	NewSchema(schema).MustMigrate(ctx, db)
+}
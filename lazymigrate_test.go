@@ -0,0 +1,1139 @@
+package lazymigrate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+)
+
+const testMagic = "-- migrate"
+
+func TestVersionsWhitespace(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []string
+		// invalid is true if Validate should report the schema.
+		invalid bool
+	}{
+		{
+			name:   "plain",
+			schema: "a\n-- migrate\nb",
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "trailing space",
+			schema: "a\n-- migrate  \nb",
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "leading tab",
+			schema: "a\n\t-- migrate\nb",
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "trailing tab and CRLF",
+			schema: "a\r\n-- migrate\t\r\nb",
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "not a whole line",
+			schema: "a\n-- migrate now\nb",
+			want:   []string{"a\n-- migrate now\nb"},
+		},
+		{
+			name:   "inner space",
+			schema: "a\n--  migrate\nb",
+			want:   []string{"a\n--  migrate\nb"},
+		},
+		{
+			name:   "trailing newline",
+			schema: "a\n-- migrate\nb\n",
+			want:   []string{"a", "b\n"},
+		},
+		{
+			name:   "no trailing newline after CRLF",
+			schema: "a\r\n-- migrate\r\nCREATE TABLE b (id INTEGER);",
+			want:   []string{"a", "CREATE TABLE b (id INTEGER);"},
+		},
+		{
+			name:   "byte order mark",
+			schema: "\uFEFFa\n-- migrate\nb",
+			want:   []string{"a", "b"},
+		},
+		{
+			name:    "byte order mark before magic comment",
+			schema:  "\uFEFF-- migrate\na\n-- migrate\nb",
+			want:    []string{"", "a", "b"},
+			invalid: true,
+		},
+		{
+			name:    "magic comment as last line",
+			schema:  "a\n-- migrate",
+			want:    []string{"a", ""},
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSchemaWithMagic(test.schema, testMagic)
+			if got := s.Versions(); !slices.Equal(got, test.want) {
+				t.Errorf("Versions() = %q, want %q", got, test.want)
+			}
+			if err := s.Validate(); (err != nil) != test.invalid {
+				t.Errorf("Validate() = %v, want an error = %v", err, test.invalid)
+			}
+		})
+	}
+}
+
+func TestVersionsCRLF(t *testing.T) {
+	schema := "" +
+		"CREATE TABLE a (id INTEGER);\r\n" +
+		"CREATE TABLE b (id INTEGER);\r\n" +
+		Delimiter + "\r\n" +
+		"CREATE TABLE c (id INTEGER);\r\n" +
+		Delimiter + "\r\n" +
+		"CREATE TABLE d (id INTEGER);\r\n"
+
+	want := []string{
+		"CREATE TABLE a (id INTEGER);\r\nCREATE TABLE b (id INTEGER);",
+		"CREATE TABLE c (id INTEGER);",
+		"CREATE TABLE d (id INTEGER);\r\n",
+	}
+
+	s := NewSchema(schema)
+	if n := s.VersionCount(); n != len(want) {
+		t.Fatalf("VersionCount() = %d, want %d", n, len(want))
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+}
+
+func TestVersionSpans(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []VersionSpan
+	}{
+		{
+			name:   "one version",
+			schema: "a",
+			want:   []VersionSpan{{0, 1}},
+		},
+		{
+			name:   "LF",
+			schema: "a\n-- migrate\nbc",
+			want:   []VersionSpan{{0, 1}, {13, 15}},
+		},
+		{
+			name:   "CRLF",
+			schema: "a\r\n-- migrate\r\nbc",
+			want:   []VersionSpan{{0, 1}, {15, 17}},
+		},
+		{
+			name:   "indented magic comment",
+			schema: "a\n  -- migrate\nb\n-- migrate\nc",
+			want:   []VersionSpan{{0, 1}, {15, 16}, {28, 29}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSchemaWithMagic(test.schema, testMagic)
+
+			spans := s.VersionSpans()
+			if !slices.Equal(spans, test.want) {
+				t.Errorf("VersionSpans() = %v, want %v", spans, test.want)
+			}
+
+			versions := s.Versions()
+			if len(versions) != len(spans) {
+				t.Fatalf("%d spans for %d versions", len(spans), len(versions))
+			}
+			for i, span := range spans {
+				if got := test.schema[span.Start:span.End]; got != versions[i] {
+					t.Errorf("span %d has %q, want version %q", i, got, versions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateEmptyVersions(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		empty  bool // whether the error wraps ErrEmptyVersion
+	}{
+		{
+			name:   "double delimiter",
+			schema: "a\n-- migrate\n-- migrate\nb",
+			empty:  true,
+		},
+		{
+			name:   "whitespace only version",
+			schema: "a\n-- migrate\n  \n\t\n-- migrate\nb",
+			empty:  true,
+		},
+		{
+			name:   "leading delimiter",
+			schema: "-- migrate\na\n-- migrate\nb",
+		},
+		{
+			name:   "trailing delimiter",
+			schema: "a\n-- migrate\nb\n-- migrate\n",
+		},
+		{
+			name:   "empty schema",
+			schema: " \n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewSchemaWithMagic(test.schema, testMagic).Validate()
+			if err == nil {
+				t.Fatal("Validate() = nil, want an error")
+			}
+			if empty := errors.Is(err, ErrEmptyVersion); empty != test.empty {
+				t.Errorf("Validate() = %v, wrapping ErrEmptyVersion = %v, want %v", err, empty, test.empty)
+			}
+		})
+	}
+
+	if err := NewSchemaWithMagic("a\n-- migrate\nb", testMagic).Validate(); err != nil {
+		t.Errorf("Validate() on a valid schema = %v, want nil", err)
+	}
+}
+
+func TestValidateDuplicateVersions(t *testing.T) {
+	tests := []struct {
+		name      string
+		schema    string
+		duplicate bool
+	}{
+		{
+			name:      "adjacent",
+			schema:    "a\n-- migrate\nb\n-- migrate\nb",
+			duplicate: true,
+		},
+		{
+			name:      "apart",
+			schema:    "a\n-- migrate\nb\n-- migrate\na",
+			duplicate: true,
+		},
+		{
+			name:      "surrounding whitespace",
+			schema:    "a\n-- migrate\n\n\ta  \n",
+			duplicate: true,
+		},
+		{
+			name:   "different inner whitespace",
+			schema: "a b\n-- migrate\na  b",
+		},
+		{
+			name:   "different case",
+			schema: "a\n-- migrate\nA",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewSchemaWithMagic(test.schema, testMagic).Validate()
+			if duplicate := errors.Is(err, ErrDuplicateVersion); duplicate != test.duplicate {
+				t.Errorf("Validate() = %v, wrapping ErrDuplicateVersion = %v, want %v", err, duplicate, test.duplicate)
+			}
+			if err != nil && !test.duplicate {
+				t.Error("Validate() on a schema without duplicates:", err)
+			}
+		})
+	}
+}
+
+func TestValidateMagic(t *testing.T) {
+	tests := []struct {
+		magic string
+		valid bool
+	}{
+		{"-- migrate", true},
+		{Delimiter, true},
+		{"---", true},
+		{"", false},
+		{" \t", false},
+		{"-- migrate\n", false},
+		{"-- a\r\n-- b", false},
+		{" -- migrate", false},
+		{"-- migrate\t", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateMagic(test.magic)
+		if (err == nil) != test.valid {
+			t.Errorf("ValidateMagic(%q) = %v, want valid = %v", test.magic, err, test.valid)
+		}
+
+		_, err = NewSchemaWithMagicChecked("a", test.magic)
+		if (err == nil) != test.valid {
+			t.Errorf("NewSchemaWithMagicChecked(%q) = %v, want valid = %v", test.magic, err, test.valid)
+		}
+	}
+}
+
+func TestNewSchemaWithMagics(t *testing.T) {
+	schema := "" +
+		"CREATE TABLE a (id INTEGER);\n" +
+		Delimiter + "\n" +
+		"CREATE TABLE b (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE c (id INTEGER);\n" +
+		Delimiter + "\n" +
+		"CREATE TABLE d (id INTEGER);"
+
+	want := []string{
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);",
+		"CREATE TABLE c (id INTEGER);",
+		"CREATE TABLE d (id INTEGER);",
+	}
+
+	s := NewSchemaWithMagics(schema, testMagic, Delimiter)
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+	if magic := s.Magic(); magic != testMagic {
+		t.Errorf("Magic() = %q, want %q", magic, testMagic)
+	}
+
+	// Without any magic comments, the default one is used.
+	if got := NewSchemaWithMagics(schema).VersionCount(); got != 3 {
+		t.Errorf("VersionCount() with the default magic = %d, want 3", got)
+	}
+}
+
+func TestNewSchemaFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"schema.sql":  {Data: []byte("CREATE TABLE a (id INTEGER);\n" + Delimiter + "\nCREATE TABLE b (id INTEGER);")},
+		"migrate.sql": {Data: []byte("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);")},
+	}
+	want := []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}
+
+	s, err := NewSchemaFromFS(fsys, "schema.sql")
+	if err != nil {
+		t.Fatal("cannot read schema:", err)
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+
+	s, err = NewSchemaFromFSWithMagic(fsys, "migrate.sql", testMagic)
+	if err != nil {
+		t.Fatal("cannot read schema with a magic comment:", err)
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() with a magic comment = %q, want %q", got, want)
+	}
+
+	if _, err := NewSchemaFromFS(fsys, "missing.sql"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewSchemaFromFS() on a missing file = %v, want an error wrapping fs.ErrNotExist", err)
+	}
+	if _, err := NewSchemaFromFSWithMagic(fsys, "migrate.sql", " "); err == nil {
+		t.Error("NewSchemaFromFSWithMagic() with an empty magic comment succeeded")
+	}
+}
+
+func TestNewSchemaFromFSGzip(t *testing.T) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte("CREATE TABLE a (id INTEGER);\n" + Delimiter + "\nCREATE TABLE b (id INTEGER);"))
+	w.Close()
+
+	fsys := fstest.MapFS{
+		"schema.sql.gz": {Data: b.Bytes()},
+		"schema.sql":    {Data: []byte("CREATE TABLE a (id INTEGER);")},
+	}
+
+	s, err := NewSchemaFromFSGzip(fsys, "schema.sql.gz")
+	if err != nil {
+		t.Fatal("cannot read schema:", err)
+	}
+	want := []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+
+	if _, err := NewSchemaFromFSGzip(fsys, "missing.sql.gz"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewSchemaFromFSGzip() on a missing file = %v, want an error wrapping fs.ErrNotExist", err)
+	}
+	if _, err := NewSchemaFromFSGzip(fsys, "schema.sql"); !errors.Is(err, gzip.ErrHeader) {
+		t.Errorf("NewSchemaFromFSGzip() on an uncompressed file = %v, want an error wrapping gzip.ErrHeader", err)
+	}
+}
+
+func TestNewSchemaFromReader(t *testing.T) {
+	want := []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}
+
+	s, err := NewSchemaFromReader(strings.NewReader("CREATE TABLE a (id INTEGER);\n" + Delimiter + "\nCREATE TABLE b (id INTEGER);"))
+	if err != nil {
+		t.Fatal("cannot read schema:", err)
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+
+	s, err = NewSchemaFromReaderWithMagic(strings.NewReader("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"), testMagic)
+	if err != nil {
+		t.Fatal("cannot read schema with a magic comment:", err)
+	}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() with a magic comment = %q, want %q", got, want)
+	}
+
+	failure := errors.New("connection reset")
+	if _, err := NewSchemaFromReader(iotest.ErrReader(failure)); !errors.Is(err, failure) {
+		t.Errorf("NewSchemaFromReader() on a failing reader = %v, want %v", err, failure)
+	}
+}
+
+func TestPerVersionTimeout(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	f.hang["SELECT slow();"] = true
+
+	schema := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nSELECT 1;\nSELECT slow();", testMagic)
+	schema.PerVersionTimeout = 10 * time.Millisecond
+
+	err := schema.Migrate(context.Background(), db)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Migrate() = %v, want an error wrapping context.DeadlineExceeded", err)
+	}
+
+	var merr *MigrationError
+	if !errors.As(err, &merr) {
+		t.Fatalf("Migrate() = %v, want an error wrapping *MigrationError", err)
+	}
+	if merr.Index != 1 || merr.SQL != "SELECT slow();" || merr.Offset != 10 || merr.Line != 3 {
+		t.Errorf("MigrationError = %#v, want the statement at offset 10 of version 1 on line 3", merr)
+	}
+}
+
+func TestMigrateCanceled(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+	s.AfterVersion = func(ctx context.Context, index int, sql string) error {
+		cancel()
+		return nil
+	}
+
+	if err := s.Migrate(ctx, db); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Migrate() = %v, want an error wrapping context.Canceled", err)
+	}
+
+	// The second version is not started once the context is canceled.
+	for _, stmt := range f.statements() {
+		if stmt == "CREATE TABLE b (id INTEGER);" {
+			t.Errorf("executed %q after the context was canceled", stmt)
+		}
+	}
+}
+
+func TestMigrateHooks(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);\n-- migrate\nCREATE TABLE c (id INTEGER);"
+
+	tests := []struct {
+		name string
+		// fail is the hook call that fails, if any.
+		fail   string
+		events []string
+		want   []string
+	}{
+		{
+			name: "success",
+			events: []string{
+				"before 1 CREATE TABLE b (id INTEGER);", "after 1 CREATE TABLE b (id INTEGER);",
+				"before 2 CREATE TABLE c (id INTEGER);", "after 2 CREATE TABLE c (id INTEGER);",
+			},
+			want: []string{
+				"BEGIN IMMEDIATE",
+				"CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 2",
+				"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 3",
+				"COMMIT",
+			},
+		},
+		{
+			name:   "before fails",
+			fail:   "before 2 CREATE TABLE c (id INTEGER);",
+			events: []string{"before 1 CREATE TABLE b (id INTEGER);", "after 1 CREATE TABLE b (id INTEGER);", "before 2 CREATE TABLE c (id INTEGER);"},
+			want:   []string{"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 2", "ROLLBACK"},
+		},
+		{
+			name:   "after fails",
+			fail:   "after 1 CREATE TABLE b (id INTEGER);",
+			events: []string{"before 1 CREATE TABLE b (id INTEGER);", "after 1 CREATE TABLE b (id INTEGER);"},
+			want:   []string{"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "ROLLBACK"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 1)
+
+			failure := errors.New("hook failed")
+
+			var events []string
+			hook := func(name string) Hook {
+				return func(ctx context.Context, index int, sql string) error {
+					event := fmt.Sprintf("%s %d %s", name, index, sql)
+					events = append(events, event)
+					if event == test.fail {
+						return failure
+					}
+					return nil
+				}
+			}
+
+			s := NewSchemaWithMagic(schema, testMagic)
+			s.BeforeVersion = hook("before")
+			s.AfterVersion = hook("after")
+
+			err := s.Migrate(context.Background(), db)
+			if test.fail == "" && err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+			if test.fail != "" && !errors.Is(err, failure) {
+				t.Fatalf("Migrate() = %v, want %v", err, failure)
+			}
+
+			if !slices.Equal(events, test.events) {
+				t.Errorf("hooks were called with %q, want %q", events, test.events)
+			}
+			if stmts := f.statements(); !slices.Equal(stmts, test.want) {
+				t.Errorf("executed %q, want %q", stmts, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateManageVersion(t *testing.T) {
+	f, db := newFakeDB(t, 1)
+
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+	s.ManageVersion = false
+
+	if err := s.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	// The version is still read to skip applied versions, but never written.
+	want := []string{"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "COMMIT"}
+	if stmts := f.statements(); !slices.Equal(stmts, want) {
+		t.Errorf("executed %q, want %q", stmts, want)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);\n-- migrate\nCREATE TABLE c (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		v       int
+		target  int
+		applied []string
+		// down is true if MigrateTo should refuse to lower the version.
+		down bool
+	}{
+		{name: "first", v: 0, target: 1, applied: []string{"CREATE TABLE a (id INTEGER);"}},
+		{name: "between", v: 1, target: 2, applied: []string{"CREATE TABLE b (id INTEGER);"}},
+		{name: "clamped", v: 1, target: 10, applied: []string{"CREATE TABLE b (id INTEGER);", "CREATE TABLE c (id INTEGER);"}},
+		{name: "at target", v: 2, target: 2},
+		{name: "below current", v: 2, target: 1, down: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			err := NewSchemaWithMagic(schema, testMagic).MigrateTo(context.Background(), db, test.target)
+			if (err != nil) != test.down {
+				t.Fatalf("MigrateTo() = %v, want an error = %v", err, test.down)
+			}
+
+			var applied []string
+			for _, stmt := range f.statements() {
+				if strings.HasPrefix(stmt, "CREATE") {
+					applied = append(applied, stmt)
+				}
+			}
+			if !slices.Equal(applied, test.applied) {
+				t.Errorf("applied %q, want %q", applied, test.applied)
+			}
+		})
+	}
+}
+
+func TestMigrateWithOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  *sql.TxOptions
+		begin string
+	}{
+		{name: "nil", begin: "BEGIN IMMEDIATE"},
+		{name: "default", opts: &sql.TxOptions{}, begin: "BEGIN"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+
+			s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+			if err := s.MigrateWithOptions(context.Background(), db, test.opts); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			want := []string{test.begin, "CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1", "COMMIT"}
+			if stmts := f.statements(); !slices.Equal(stmts, want) {
+				t.Errorf("executed %q, want %q", stmts, want)
+			}
+		})
+	}
+}
+
+func TestMigrateStoredVersions(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		v       int
+		strict  bool
+		onAhead AheadPolicy
+		applied []string
+		err     error
+	}{
+		{
+			name:    "negative",
+			v:       -3,
+			applied: []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"},
+		},
+		{
+			name:   "negative strict",
+			v:      -3,
+			strict: true,
+			err:    ErrInvalidVersion,
+		},
+		{
+			name:    "zero",
+			v:       0,
+			applied: []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"},
+		},
+		{
+			name:    "between",
+			v:       1,
+			applied: []string{"CREATE TABLE b (id INTEGER);"},
+		},
+		{
+			name: "exact",
+			v:    2,
+		},
+		{
+			name: "beyond",
+			v:    5,
+		},
+		{
+			name:    "beyond error",
+			v:       5,
+			onAhead: OnAheadError,
+			err:     ErrDatabaseAhead,
+		},
+		{
+			name:   "beyond strict",
+			v:      5,
+			strict: true,
+			err:    ErrDatabaseAhead,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			s := NewSchemaWithMagic(schema, testMagic)
+			s.Strict = test.strict
+			s.OnAhead = test.onAhead
+
+			err := s.Migrate(context.Background(), db)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Migrate() = %v, want %v", err, test.err)
+			}
+
+			var applied []string
+			for _, stmt := range f.statements() {
+				if strings.HasPrefix(stmt, "CREATE") {
+					applied = append(applied, stmt)
+				}
+			}
+			if !slices.Equal(applied, test.applied) {
+				t.Errorf("applied %q, want %q", applied, test.applied)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		v       int
+		strict  bool
+		current int
+		err     error
+	}{
+		{name: "fresh", v: 0, current: 0},
+		{name: "up to date", v: 2, current: 2},
+		{name: "ahead", v: 5, current: 5},
+		{name: "ahead strict", v: 5, strict: true, err: ErrVersionAhead},
+		{name: "negative", v: -1, current: -1},
+		{name: "negative strict", v: -1, strict: true, err: ErrInvalidVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			s := NewSchemaWithMagic(schema, testMagic)
+			s.Strict = test.strict
+
+			current, latest, err := s.Status(context.Background(), db)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Status() = %v, want %v", err, test.err)
+			}
+			if err == nil && (current != test.current || latest != 2) {
+				t.Errorf("Status() = (%d, %d), want (%d, 2)", current, latest, test.current)
+			}
+
+			// The version is read in a transaction that is rolled back.
+			want := []string{"BEGIN", "ROLLBACK"}
+			if stmts := f.statements(); !slices.Equal(stmts, want) {
+				t.Errorf("executed %q, want %q", stmts, want)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);\n-- migrate\nCREATE TABLE c (id INTEGER);"
+
+	tests := []struct {
+		name string
+		v    int
+		want Plan
+	}{
+		{name: "fresh", v: 0, want: Plan{Current: 0, Latest: 3, Pending: []int{0, 1, 2}}},
+		{name: "between", v: 2, want: Plan{Current: 2, Latest: 3, Pending: []int{2}}},
+		{name: "up to date", v: 3, want: Plan{Current: 3, Latest: 3}},
+		{name: "ahead", v: 5, want: Plan{Current: 5, Latest: 3}},
+		{name: "negative", v: -1, want: Plan{Current: -1, Latest: 3, Pending: []int{0, 1, 2}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, db := newFakeDB(t, test.v)
+
+			got, err := NewSchemaWithMagic(schema, testMagic).Plan(context.Background(), db)
+			if err != nil {
+				t.Fatal("cannot plan:", err)
+			}
+			if got.Current != test.want.Current || got.Latest != test.want.Latest || !slices.Equal(got.Pending, test.want.Pending) {
+				t.Errorf("Plan() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNeedsMigration(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name string
+		v    int
+		want bool
+	}{
+		{name: "fresh", v: 0, want: true},
+		{name: "between", v: 1, want: true},
+		{name: "up to date", v: 2, want: false},
+		{name: "ahead", v: 5, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			got, err := NewSchemaWithMagic(schema, testMagic).NeedsMigration(context.Background(), db)
+			if err != nil {
+				t.Fatal("cannot check for migrations:", err)
+			}
+			if got != test.want {
+				t.Errorf("NeedsMigration() = %v, want %v", got, test.want)
+			}
+
+			// The version is read without a transaction.
+			if stmts := f.statements(); len(stmts) != 0 {
+				t.Errorf("executed %q, want nothing", stmts)
+			}
+		})
+	}
+}
+
+func TestPendingVersions(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name string
+		v    int
+		want []string
+	}{
+		{name: "fresh", v: 0, want: []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}},
+		{name: "between", v: 1, want: []string{"CREATE TABLE b (id INTEGER);"}},
+		{name: "up to date", v: 2},
+		{name: "negative", v: -1, want: []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"}},
+		{name: "ahead", v: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			got, err := NewSchemaWithMagic(schema, testMagic).PendingVersions(context.Background(), db)
+			if err != nil {
+				t.Fatal("cannot get pending versions:", err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("PendingVersions() = %q, want %q", got, test.want)
+			}
+
+			if stmts := f.statements(); len(stmts) != 0 {
+				t.Errorf("executed %q, want nothing", stmts)
+			}
+		})
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		v       int
+		strict  bool
+		onAhead AheadPolicy
+		want    int
+		err     error
+	}{
+		{name: "fresh", v: 0, want: 0},
+		{name: "between", v: 1, want: 1},
+		{name: "up to date", v: 2, want: 2},
+		{name: "negative", v: -1, want: 0},
+		{name: "negative strict", v: -1, strict: true, err: ErrInvalidVersion},
+		{name: "ahead", v: 5, want: 2},
+		{name: "ahead error", v: 5, onAhead: OnAheadError, err: ErrVersionAhead},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			s := NewSchemaWithMagic(schema, testMagic)
+			s.Strict = test.strict
+			s.OnAhead = test.onAhead
+
+			got, err := s.NextVersion(context.Background(), db)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("NextVersion() = %v, want %v", err, test.err)
+			}
+			if err == nil && got != test.want {
+				t.Errorf("NextVersion() = %d, want %d", got, test.want)
+			}
+
+			if stmts := f.statements(); len(stmts) != 0 {
+				t.Errorf("executed %q, want nothing", stmts)
+			}
+		})
+	}
+}
+
+func TestMigrateEachCommit(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);\n-- migrate\nCREATE TABLE c (id INTEGER);"
+
+	tests := []struct {
+		name string
+		fail string
+		want []string
+	}{
+		{
+			name: "success",
+			want: []string{
+				"BEGIN IMMEDIATE", "CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1", "COMMIT",
+				"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 2", "COMMIT",
+				"BEGIN IMMEDIATE", "CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 3", "COMMIT",
+				// The last transaction only finds that nothing is pending.
+				"BEGIN IMMEDIATE", "COMMIT",
+			},
+		},
+		{
+			name: "failure",
+			fail: "CREATE TABLE b (id INTEGER);",
+			want: []string{
+				"BEGIN IMMEDIATE", "CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1", "COMMIT",
+				"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "ROLLBACK",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+
+			failure := errors.New("disk is full")
+			if test.fail != "" {
+				f.errs[test.fail] = failure
+			}
+
+			err := NewSchemaWithMagic(schema, testMagic).MigrateEachCommit(context.Background(), db)
+			if test.fail == "" && err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+			if test.fail != "" && !errors.Is(err, failure) {
+				t.Fatalf("MigrateEachCommit() = %v, want %v", err, failure)
+			}
+
+			if stmts := f.statements(); !slices.Equal(stmts, test.want) {
+				t.Errorf("executed %q, want %q", stmts, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateRewrite(t *testing.T) {
+	const schema = "CREATE TABLE {prefix}a (id INTEGER);\n-- migrate\nCREATE TABLE {prefix}b (id INTEGER);"
+
+	f, db := newFakeDB(t, 0)
+
+	var indices []int
+	s := NewSchemaWithMagic(schema, testMagic)
+	s.Rewrite = func(index int, sql string) (string, error) {
+		indices = append(indices, index)
+		return strings.ReplaceAll(sql, "{prefix}", "app_"), nil
+	}
+
+	if err := s.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	if want := []int{0, 1}; !slices.Equal(indices, want) {
+		t.Errorf("Rewrite was called with %v, want %v", indices, want)
+	}
+
+	want := []string{
+		"BEGIN IMMEDIATE",
+		"CREATE TABLE app_a (id INTEGER);", "PRAGMA user_version = 1",
+		"CREATE TABLE app_b (id INTEGER);", "PRAGMA user_version = 2",
+		"COMMIT",
+	}
+	if stmts := f.statements(); !slices.Equal(stmts, want) {
+		t.Errorf("executed %q, want %q", stmts, want)
+	}
+}
+
+func TestMigrateRewriteError(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	failure := errors.New("unknown placeholder")
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE {nope} (id INTEGER);", testMagic)
+	s.Rewrite = func(index int, sql string) (string, error) {
+		if strings.Contains(sql, "{nope}") {
+			return "", failure
+		}
+		return sql, nil
+	}
+
+	if err := s.Migrate(context.Background(), db); !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+
+	want := []string{"BEGIN IMMEDIATE", "CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1", "ROLLBACK"}
+	if stmts := f.statements(); !slices.Equal(stmts, want) {
+		t.Errorf("executed %q, want %q", stmts, want)
+	}
+}
+
+func TestMigrateGuard(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);\n-- migrate\nCREATE TABLE c (id INTEGER);"
+
+	t.Run("skip", func(t *testing.T) {
+		f, db := newFakeDB(t, 0)
+
+		s := NewSchemaWithMagic(schema, testMagic)
+		s.Guard = func(index int, sql string) (bool, error) {
+			return index != 1, nil
+		}
+
+		if err := s.Migrate(context.Background(), db); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		// The skipped version still advances the version.
+		want := []string{
+			"BEGIN IMMEDIATE",
+			"CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1",
+			"PRAGMA user_version = 2",
+			"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 3",
+			"COMMIT",
+		}
+		if stmts := f.statements(); !slices.Equal(stmts, want) {
+			t.Errorf("executed %q, want %q", stmts, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		f, db := newFakeDB(t, 0)
+
+		failure := errors.New("feature flags are unavailable")
+		s := NewSchemaWithMagic(schema, testMagic)
+		s.Guard = func(index int, sql string) (bool, error) {
+			if index == 1 {
+				return false, failure
+			}
+			return true, nil
+		}
+
+		if err := s.Migrate(context.Background(), db); !errors.Is(err, failure) {
+			t.Fatalf("Migrate() = %v, want %v", err, failure)
+		}
+
+		want := []string{"BEGIN IMMEDIATE", "CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1", "ROLLBACK"}
+		if stmts := f.statements(); !slices.Equal(stmts, want) {
+			t.Errorf("executed %q, want %q", stmts, want)
+		}
+	})
+}
+
+func TestMigrateUnterminated(t *testing.T) {
+	// The stray "/*" swallows the magic comment, so the schema would count as
+	// a single version.
+	const schema = "CREATE TABLE a (id INTEGER);\n/* a stray comment\n-- migrate\nCREATE TABLE b (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		migrate func(*Schema, *sql.DB) error
+	}{
+		{"Migrate", func(s *Schema, db *sql.DB) error {
+			return s.Migrate(context.Background(), db)
+		}},
+		{"MigrateN", func(s *Schema, db *sql.DB) error {
+			n, err := s.MigrateN(context.Background(), db)
+			if n != 0 {
+				t.Errorf("MigrateN() applied %d versions, want 0", n)
+			}
+			return err
+		}},
+		{"MigrateFrom", func(s *Schema, db *sql.DB) error {
+			return s.MigrateFrom(context.Background(), db, 0)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+
+			err := test.migrate(NewSchemaWithMagic(schema, testMagic), db)
+			if !errors.Is(err, ErrUnterminated) {
+				t.Fatalf("%s() = %v, want %v", test.name, err, ErrUnterminated)
+			}
+
+			for _, stmt := range f.statements() {
+				if strings.HasPrefix(stmt, "CREATE") || strings.HasPrefix(stmt, "PRAGMA user_version =") {
+					t.Errorf("executed %q", stmt)
+				}
+			}
+		})
+	}
+}
+
+// largeSchema returns a schema string of about 5 MB with 500 versions.
+func largeSchema() string {
+	versions := make([]string, 500)
+	for i := range versions {
+		var version strings.Builder
+		for j := 0; j < 100; j++ {
+			fmt.Fprintf(&version, "CREATE TABLE t%d_%d (id INTEGER PRIMARY KEY, name TEXT NOT NULL, created_at INTEGER);\n", i, j)
+		}
+		versions[i] = version.String()
+	}
+	return strings.Join(versions, "\n"+Delimiter+"\n")
+}
+
+func BenchmarkVersions(b *testing.B) {
+	schema := largeSchema()
+	s := NewSchema(schema)
+	b.SetBytes(int64(len(schema)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if n := len(s.Versions()); n != 500 {
+			b.Fatalf("got %d versions, want 500", n)
+		}
+	}
+}
+
+func BenchmarkEachVersion(b *testing.B) {
+	schema := largeSchema()
+	s := NewSchema(schema)
+	b.SetBytes(int64(len(schema)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var n int
+		s.EachVersion(func(index int, sql string) error {
+			n++
+			return nil
+		})
+		if n != 500 {
+			b.Fatalf("got %d versions, want 500", n)
+		}
+	}
+}
+
+func TestNewSchemaWithMatcher(t *testing.T) {
+	s := NewSchemaWithMatcher("a\n-- +migrate up\nb\n  -- +migrate down\nc", func(line string) bool {
+		return strings.HasPrefix(strings.TrimSpace(line), "-- +migrate")
+	})
+
+	want := []string{"a", "b", "c"}
+	if got := s.Versions(); !slices.Equal(got, want) {
+		t.Errorf("Versions() = %q, want %q", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("NewSchemaWithMatcher(nil) did not panic")
+		}
+	}()
+	NewSchemaWithMatcher("a\n\nb", nil)
+}
@@ -0,0 +1,52 @@
+// Package lazymigratetest provides helpers for testing code that uses
+// lazymigrate schemas.
+//
+// It does not import any SQLite driver. The test binary must import one, and
+// [DriverName] must be set to its name if it is not "sqlite3".
+package lazymigratetest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"libdb.so/lazymigrate"
+)
+
+// DriverName is the name of the database/sql driver used by [NewDB].
+var DriverName = "sqlite3"
+
+// DataSourceName is the data source name used by [NewDB]. It must open an
+// in-memory database.
+var DataSourceName = ":memory:"
+
+// NewDB opens a new in-memory SQLite database and migrates it with the given
+// schema string. The database is closed when the test ends. The test fails
+// immediately if the database cannot be opened or migrated.
+//
+// The returned database is limited to a single connection, since every
+// connection to a ":memory:" database has its own database.
+func NewDB(t testing.TB, schema string) *sql.DB {
+	t.Helper()
+	return NewDBWithSchema(t, lazymigrate.NewSchema(schema))
+}
+
+// NewDBWithSchema is like [NewDB], but it migrates the database with the given
+// Schema.
+func NewDBWithSchema(t testing.TB, schema *lazymigrate.Schema) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(DriverName, DataSourceName)
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	db.SetMaxOpenConns(1)
+
+	if err := schema.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("cannot migrate database: %v", err)
+	}
+
+	return db
+}
@@ -0,0 +1,110 @@
+package lazymigrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotTransactional is returned when a pending version contains a statement
+// that does not work within a transaction. Such versions must be applied with
+// [Schema.MigrateNoTx].
+var ErrNotTransactional = errors.New("statement does not work within a transaction")
+
+// DefaultNoTxStatements is the default list of statements that are rejected by
+// the migration methods that use a transaction. See
+// [Schema.NoTxStatements].
+var DefaultNoTxStatements = []string{
+	// Refused by SQLite within a transaction.
+	"VACUUM",
+	// Silently ignored by SQLite within a transaction.
+	"PRAGMA journal_mode",
+	"PRAGMA foreign_keys",
+	// End the migration transaction early.
+	"BEGIN",
+	"COMMIT",
+	"END",
+}
+
+// noTxStatements returns the statements to reject within a transaction.
+func (s *Schema) noTxStatements() []string {
+	if s.NoTxStatements != nil {
+		return s.NoTxStatements
+	}
+	return DefaultNoTxStatements
+}
+
+// checkTransactional returns an error wrapping [ErrNotTransactional] if any of
+// the versions from from to to contains a statement that starts with one of
+// the Schema's NoTxStatements. It also returns an error wrapping
+// [ErrUnterminated] if the schema is not terminated, since the versions are
+// then not the ones that were meant to be applied.
+func (s *Schema) checkTransactional(from, to int) error {
+	if err := s.checkTerminated(); err != nil {
+		return err
+	}
+
+	keywords := s.noTxStatements()
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	err := s.EachVersion(func(i int, version string) error {
+		if i < from {
+			return nil
+		}
+		if i >= to {
+			return errStop
+		}
+
+		up, _, _ := splitDown(version)
+		stmts, err := splitStatements(up)
+		if err != nil {
+			return fmt.Errorf("cannot split migration %d (from 0th): %w", i, err)
+		}
+
+		for _, stmt := range stmts {
+			for _, keyword := range keywords {
+				if hasLeadingWords(stmt.sql, strings.Fields(keyword)) {
+					return fmt.Errorf(
+						"migration %d (from 0th) contains %s, use Schema.MigrateNoTx to apply it: %w",
+						i, keyword, ErrNotTransactional)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+	return nil
+}
+
+// hasLeadingWords returns true if the statement starts with the given words,
+// compared case-insensitively. Words in sql are runs of identifier
+// characters, so "PRAGMA journal_mode=WAL" starts with the words "PRAGMA" and
+// "journal_mode".
+func hasLeadingWords(sql string, words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+
+	i := 0
+	for _, word := range words {
+		for i < len(sql) && isSpace(sql[i]) {
+			i++
+		}
+
+		start := i
+		for i < len(sql) && isWordPart(sql[i]) {
+			i++
+		}
+
+		if !strings.EqualFold(sql[start:i], word) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,128 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMigrateNotTransactional(t *testing.T) {
+	const first = "CREATE TABLE a (id INTEGER);\n-- migrate\n"
+
+	tests := []struct {
+		name     string
+		second   string
+		stored   int
+		keywords []string
+		reject   bool
+	}{
+		{
+			name:   "VACUUM",
+			second: "VACUUM;",
+			reject: true,
+		},
+		{
+			name:   "lowercase",
+			second: "vacuum;",
+			reject: true,
+		},
+		{
+			name:   "pragma with spaces",
+			second: "PRAGMA  journal_mode = WAL;",
+			reject: true,
+		},
+		{
+			name:   "pragma without spaces",
+			second: "PRAGMA journal_mode=WAL;",
+			reject: true,
+		},
+		{
+			name:   "after another statement",
+			second: "CREATE TABLE b (id INTEGER);\nCOMMIT;",
+			reject: true,
+		},
+		{
+			name:   "longer word",
+			second: "CREATE TABLE vacuum_log (id INTEGER);\nPRAGMA journal_mode_x;",
+		},
+		{
+			name:   "other pragma",
+			second: "PRAGMA user_version;",
+		},
+		{
+			name:   "in a comment",
+			second: "-- VACUUM;\nCREATE TABLE b (id INTEGER);",
+		},
+		{
+			name:   "in a string",
+			second: "INSERT INTO a VALUES ('\nVACUUM');",
+		},
+		{
+			name:   "trigger body",
+			second: "CREATE TRIGGER t AFTER INSERT ON a BEGIN SELECT 1; END;",
+		},
+		{
+			name:   "already applied",
+			second: "VACUUM;",
+			stored: 2,
+		},
+		{
+			name:     "custom list",
+			second:   "ANALYZE;",
+			keywords: []string{"ANALYZE"},
+			reject:   true,
+		},
+		{
+			name:     "custom list without the default",
+			second:   "VACUUM;",
+			keywords: []string{"ANALYZE"},
+		},
+		{
+			name:     "empty list",
+			second:   "VACUUM;",
+			keywords: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.stored)
+
+			s := NewSchemaWithMagic(first+test.second, testMagic)
+			s.NoTxStatements = test.keywords
+
+			err := s.Migrate(context.Background(), db)
+			if rejected := errors.Is(err, ErrNotTransactional); rejected != test.reject {
+				t.Fatalf("Migrate() = %v, want rejected = %v", err, test.reject)
+			}
+			if err != nil && !test.reject {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			// Rejected versions are found before any version is applied, so
+			// the first one is not applied either.
+			want := []string{"BEGIN IMMEDIATE", "ROLLBACK"}
+			if stmts := f.statements(); test.reject && !slices.Equal(stmts, want) {
+				t.Errorf("executed %q, want %q", stmts, want)
+			}
+		})
+	}
+}
+
+func TestMigrateNoTxNotTransactional(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nVACUUM;", testMagic)
+
+	if err := s.MigrateNoTx(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate without a transaction:", err)
+	}
+
+	var vacuumed bool
+	for _, stmt := range f.statements() {
+		vacuumed = vacuumed || stmt == "VACUUM;"
+	}
+	if !vacuumed {
+		t.Errorf("executed %q, want VACUUM", f.statements())
+	}
+}
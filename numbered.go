@@ -0,0 +1,170 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// versionNumber returns the explicit number of a version, which is given by a
+// "-- version N" line comment on its first non-blank line.
+func versionNumber(version string) (int, bool) {
+	for version != "" {
+		line := version
+		if i := strings.IndexByte(version, '\n'); i != -1 {
+			line, version = version[:i], version[i+1:]
+		} else {
+			version = ""
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		comment, ok := strings.CutPrefix(line, "--")
+		if !ok {
+			return 0, false
+		}
+
+		fields := strings.Fields(comment)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "version") {
+			return 0, false
+		}
+
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// VersionNumbers returns the explicit number of each version of the schema,
+// as used when [Schema.NumberedVersions] is set. Each version must start with
+// a line comment like
+//
+//	-- version 5
+//
+// and the numbers must be positive and strictly increasing, but they may have
+// gaps.
+func (s *Schema) VersionNumbers() ([]int, error) {
+	var numbers []int
+	err := s.EachVersion(func(i int, version string) error {
+		n, ok := versionNumber(version)
+		if !ok {
+			return fmt.Errorf("version %d (from 0th) does not start with a -- version N comment", i)
+		}
+		if n < 1 {
+			return fmt.Errorf("version %d (from 0th) has non-positive number %d", i, n)
+		}
+		if len(numbers) > 0 && n <= numbers[len(numbers)-1] {
+			return fmt.Errorf("version %d (from 0th) has number %d, which is not greater than %d before it",
+				i, n, numbers[len(numbers)-1])
+		}
+		numbers = append(numbers, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return numbers, nil
+}
+
+// getVersion returns the current version of the database as the number of
+// versions of the schema that were applied. If NumberedVersions is set, the
+// stored version number is translated to that count.
+func (s *Schema) getVersion(ctx context.Context, q DBTX) (int, error) {
+	// Every method that accesses the database reads its version first, so
+	// this is where an error from reading a lazily loaded schema surfaces.
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+
+	v, err := s.readStored(ctx, q)
+	if err != nil || !s.NumberedVersions || v <= 0 {
+		return v, err
+	}
+
+	numbers, err := s.numbers()
+	if err != nil {
+		return 0, err
+	}
+
+	// Every version with a number up to the stored one counts as applied, so
+	// removing an applied version does not shift the ones after it.
+	n := 0
+	for n < len(numbers) && numbers[n] <= v {
+		n++
+	}
+
+	// Keep a database that is ahead of the schema ahead of it.
+	if n == len(numbers) && n > 0 && v > numbers[n-1] {
+		n += v - numbers[n-1]
+	}
+
+	return n, nil
+}
+
+// setVersion records that the first v versions of the schema are applied. If
+// NumberedVersions is set, the number of the last of them is stored instead.
+// The numbers are the ones returned by numbers, which callers parse once
+// instead of for every version they apply.
+func (s *Schema) setVersion(ctx context.Context, q DBTX, v int, numbers []int) error {
+	stored, err := storedVersion(v, numbers)
+	if err != nil {
+		return err
+	}
+	return s.writeStored(ctx, q, stored)
+}
+
+// storedVersion returns the value that setVersion stores for the count of
+// applied versions v, given the version numbers returned by numbers.
+func storedVersion(v int, numbers []int) (int, error) {
+	if numbers == nil || v <= 0 {
+		return v, nil
+	}
+
+	if v > len(numbers) {
+		return 0, fmt.Errorf("cannot set version %d: schema only has %d versions", v, len(numbers))
+	}
+
+	return numbers[v-1], nil
+}
+
+// numbers returns the version numbers of the schema if NumberedVersions is
+// set, or nil otherwise. It parses the whole schema.
+func (s *Schema) numbers() ([]int, error) {
+	if !s.NumberedVersions {
+		return nil, nil
+	}
+	if s.VerifyChecksums {
+		return nil, errors.New("checksums cannot be verified with numbered versions")
+	}
+	numbers, err := s.VersionNumbers()
+	if err != nil {
+		return nil, fmt.Errorf("invalid version numbers: %w", err)
+	}
+	return numbers, nil
+}
+
+// readStored reads the stored version using GetVersion, or the version store
+// if it is nil.
+func (s *Schema) readStored(ctx context.Context, q DBTX) (int, error) {
+	if s.GetVersion != nil {
+		return s.GetVersion(ctx, q)
+	}
+	return s.store.Get(ctx, q)
+}
+
+// writeStored writes the stored version using SetVersion, or the version
+// store if it is nil.
+func (s *Schema) writeStored(ctx context.Context, q DBTX, v int) error {
+	if s.SetVersion != nil {
+		return s.SetVersion(ctx, q, v)
+	}
+	return s.store.Set(ctx, q, v)
+}
@@ -0,0 +1,125 @@
+package lazymigrate
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNumberedVersions(t *testing.T) {
+	const schema = "-- version 1\nCREATE TABLE a (id INTEGER);\n" +
+		"-- migrate\n" +
+		"-- version 5\nCREATE TABLE b (id INTEGER);\n" +
+		"-- migrate\n" +
+		"-- version 10\nCREATE TABLE c (id INTEGER);"
+
+	tests := []struct {
+		name    string
+		schema  string
+		stored  int
+		onAhead AheadPolicy
+		// from, if not 0, migrates with MigrateFrom instead of Migrate.
+		from int
+		want []string
+		err  string
+	}{
+		{
+			name:   "fresh",
+			stored: 0,
+			want: []string{
+				"CREATE TABLE a (id INTEGER);", "PRAGMA user_version = 1",
+				"CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 5",
+				"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 10",
+			},
+		},
+		{
+			name:   "after a gap",
+			stored: 5,
+			want:   []string{"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 10"},
+		},
+		{
+			name:   "within a gap",
+			stored: 3,
+			want: []string{
+				"CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 5",
+				"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 10",
+			},
+		},
+		{
+			name:   "removed version",
+			schema: strings.Replace(schema, "-- version 5\nCREATE TABLE b (id INTEGER);\n-- migrate\n", "", 1),
+			stored: 5,
+			want:   []string{"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 10"},
+		},
+		{
+			name:   "up to date",
+			stored: 10,
+		},
+		{
+			name:    "ahead",
+			stored:  12,
+			onAhead: OnAheadError,
+			err:     ErrDatabaseAhead.Error(),
+		},
+		{
+			name:   "ahead ignored",
+			stored: 12,
+		},
+		{
+			name:   "not increasing",
+			schema: strings.Replace(schema, "-- version 10", "-- version 4", 1),
+			stored: 0,
+			err:    "version 2 (from 0th) has number 4, which is not greater than 5 before it",
+		},
+		{
+			// MigrateFrom takes the count of applied versions, not a version
+			// number.
+			name: "MigrateFrom",
+			from: 1,
+			want: []string{
+				"CREATE TABLE b (id INTEGER);", "PRAGMA user_version = 5",
+				"CREATE TABLE c (id INTEGER);", "PRAGMA user_version = 10",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.stored)
+
+			src := test.schema
+			if src == "" {
+				src = schema
+			}
+
+			s := NewSchemaWithMagic(src, testMagic)
+			s.NumberedVersions = true
+			s.OnAhead = test.onAhead
+
+			var err error
+			if test.from == 0 {
+				err = s.Migrate(context.Background(), db)
+			} else {
+				err = s.MigrateFrom(context.Background(), db, test.from)
+			}
+
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatal("cannot migrate:", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("migrating = %v, want an error containing %q", err, test.err)
+			}
+
+			var got []string
+			for _, stmt := range f.statements() {
+				if strings.HasPrefix(stmt, "CREATE") || strings.HasPrefix(stmt, "PRAGMA user_version =") {
+					got = append(got, stmt)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("executed %q, want %q", got, test.want)
+			}
+		})
+	}
+}
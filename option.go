@@ -0,0 +1,92 @@
+package lazymigrate
+
+import "time"
+
+// Option configures a [Schema] made with [NewSchema]. Options are applied in
+// order, so a later option overrides an earlier one that sets the same thing.
+// Every option only sets fields that can also be set directly on the Schema,
+// apart from those that choose how versions are delimited and stored.
+type Option func(*Schema)
+
+// WithMagic delimits the versions with the given magic comment instead of
+// [Delimiter]. The magic comment must be valid as described in
+// [ValidateMagic], or WithMagic panics.
+func WithMagic(magic string) Option {
+	mustValidateMagic(magic)
+	return func(s *Schema) {
+		s.magic = magic
+		s.altMagics = nil
+		s.match = nil
+	}
+}
+
+// WithMagics is like [WithMagic], but versions are delimited by any of the
+// given magic comments, as described in [NewSchemaWithMagics]. If no magic
+// comments are given, the option does nothing.
+func WithMagics(magics ...string) Option {
+	for _, magic := range magics {
+		mustValidateMagic(magic)
+	}
+	return func(s *Schema) {
+		if len(magics) == 0 {
+			return
+		}
+		s.magic = magics[0]
+		s.altMagics = magics[1:]
+		s.match = nil
+	}
+}
+
+// WithMatcher delimits the versions with every line for which match returns
+// true instead of a magic comment, as described in [NewSchemaWithMatcher]. It
+// panics if match is nil.
+func WithMatcher(match func(line string) bool) Option {
+	if match == nil {
+		panic("lazymigrate: nil matcher")
+	}
+	return func(s *Schema) {
+		s.magic = ""
+		s.altMagics = nil
+		s.match = match
+	}
+}
+
+// WithStore tracks the version using the given [VersionStore] instead of
+// user_version.
+func WithStore(store VersionStore) Option {
+	return func(s *Schema) { s.store = store }
+}
+
+// WithLogger sets [Schema.Logger].
+func WithLogger(logger Logger) Option {
+	return func(s *Schema) { s.Logger = logger }
+}
+
+// WithOnAhead sets [Schema.OnAhead].
+func WithOnAhead(policy AheadPolicy) Option {
+	return func(s *Schema) { s.OnAhead = policy }
+}
+
+// WithPerVersionTimeout sets [Schema.PerVersionTimeout].
+func WithPerVersionTimeout(timeout time.Duration) Option {
+	return func(s *Schema) { s.PerVersionTimeout = timeout }
+}
+
+// WithHooks sets [Schema.BeforeVersion] and [Schema.AfterVersion]. Either may
+// be nil.
+func WithHooks(before, after Hook) Option {
+	return func(s *Schema) {
+		s.BeforeVersion = before
+		s.AfterVersion = after
+	}
+}
+
+// WithVerifyChecksums sets [Schema.VerifyChecksums].
+func WithVerifyChecksums(verify bool) Option {
+	return func(s *Schema) { s.VerifyChecksums = verify }
+}
+
+// WithStrict sets [Schema.Strict].
+func WithStrict(strict bool) Option {
+	return func(s *Schema) { s.Strict = strict }
+}
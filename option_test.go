@@ -0,0 +1,126 @@
+package lazymigrate
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSchemaOptions(t *testing.T) {
+	logger := log.Default()
+	hook := func(ctx context.Context, index int, sql string) error { return nil }
+
+	s := NewSchema("a",
+		WithStore(TableStore{Table: "versions"}),
+		WithLogger(logger),
+		WithOnAhead(OnAheadWarn),
+		WithPerVersionTimeout(time.Minute),
+		WithHooks(hook, nil),
+		WithVerifyChecksums(true),
+		WithStrict(true),
+	)
+
+	if s.store != (TableStore{Table: "versions"}) {
+		t.Errorf("store = %#v, want the TableStore", s.store)
+	}
+	if s.Logger != logger {
+		t.Error("Logger is not set")
+	}
+	if s.OnAhead != OnAheadWarn {
+		t.Errorf("OnAhead = %v, want OnAheadWarn", s.OnAhead)
+	}
+	if s.PerVersionTimeout != time.Minute {
+		t.Errorf("PerVersionTimeout = %v, want a minute", s.PerVersionTimeout)
+	}
+	if s.BeforeVersion == nil || s.AfterVersion != nil {
+		t.Error("WithHooks did not set only BeforeVersion")
+	}
+	if !s.VerifyChecksums || !s.Strict {
+		t.Error("VerifyChecksums or Strict is not set")
+	}
+
+	// Options do not change the defaults that they do not set.
+	if !s.ManageVersion || s.Magic() != Delimiter {
+		t.Error("options changed ManageVersion or the magic comment")
+	}
+}
+
+func TestNewSchemaDelimitOptions(t *testing.T) {
+	const schema = "a\n-- one\nb\n-- two\nc\n" + Delimiter + "\nd"
+
+	isTwo := func(line string) bool { return strings.TrimSpace(line) == "-- two" }
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{"a\n-- one\nb\n-- two\nc", "d"},
+		},
+		{
+			name: "magic",
+			opts: []Option{WithMagic("-- one")},
+			want: []string{"a", "b\n-- two\nc\n" + Delimiter + "\nd"},
+		},
+		{
+			name: "magics",
+			opts: []Option{WithMagics("-- one", "-- two")},
+			want: []string{"a", "b", "c\n" + Delimiter + "\nd"},
+		},
+		{
+			name: "no magics",
+			opts: []Option{WithMagic("-- one"), WithMagics()},
+			want: []string{"a", "b\n-- two\nc\n" + Delimiter + "\nd"},
+		},
+		{
+			name: "matcher",
+			opts: []Option{WithMatcher(isTwo)},
+			want: []string{"a\n-- one\nb", "c\n" + Delimiter + "\nd"},
+		},
+		{
+			name: "magic after matcher",
+			opts: []Option{WithMatcher(isTwo), WithMagic("-- one")},
+			want: []string{"a", "b\n-- two\nc\n" + Delimiter + "\nd"},
+		},
+		{
+			name: "matcher after magics",
+			opts: []Option{WithMagics("-- one", Delimiter), WithMatcher(isTwo)},
+			want: []string{"a\n-- one\nb", "c\n" + Delimiter + "\nd"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NewSchema(schema, test.opts...).Versions(); !slices.Equal(got, test.want) {
+				t.Errorf("Versions() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewSchemaOptionPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func() Option
+	}{
+		{"invalid magic", func() Option { return WithMagic("-- two\nlines") }},
+		{"invalid magics", func() Option { return WithMagics("-- migrate", "") }},
+		{"nil matcher", func() Option { return WithMatcher(nil) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("option did not panic")
+				}
+			}()
+			test.opt()
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WritePending writes the SQL that [Schema.Migrate] would run against db to w
+// instead of executing it, such as for code review or for producing a single
+// migration script. It reads the current version of db and writes each pending
+// version, followed by the statement that sets the new version. Nothing is
+// written to db.
+//
+// Versions are rewritten as by [Schema.Rewrite], without their down sections,
+// and wrapped as by [Schema.WrapEach]. Versions that [Schema.Guard] would skip
+// are written as a comment. The version statement is only written as SQL for
+// the default pragma store; for other stores and for [Schema.SetVersion], it
+// is written as a comment, since the statements they run cannot be known
+// without running them. If there is nothing to migrate, nothing is written.
+func (s *Schema) WritePending(ctx context.Context, db *sql.DB, w io.Writer) error {
+	v, err := s.CurrentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	versions := s.Versions()
+	if v >= len(versions) {
+		return nil
+	}
+	v = max(v, 0)
+
+	var b strings.Builder
+	for i := v; i < len(versions); i++ {
+		if err := s.writeVersion(&b, i, versions[i]); err != nil {
+			return err
+		}
+	}
+
+	if s.ManageVersion {
+		if err := s.writeSetVersion(&b, len(versions)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("cannot write pending migrations: %w", err)
+	}
+
+	return nil
+}
+
+// writeVersion writes version i to b as it would be applied.
+func (s *Schema) writeVersion(b *strings.Builder, i int, version string) error {
+	rewritten, err := s.rewrite(i, version)
+	if err != nil {
+		return err
+	}
+
+	up, _, _ := splitDown(rewritten)
+	if strings.TrimSpace(up) == "" {
+		return fmt.Errorf("cannot write migration %d (from 0th): %w", i, ErrEmptyVersion)
+	}
+
+	fmt.Fprintf(b, "-- Version %d\n", i+1)
+
+	if s.Guard != nil {
+		apply, err := s.Guard(i, version)
+		if err != nil {
+			return fmt.Errorf("Guard failed for migration %d (from 0th): %w", i, err)
+		}
+		if !apply {
+			b.WriteString("-- Skipped, since Guard returned false.\n\n")
+			return nil
+		}
+	}
+
+	var before, after string
+	if s.WrapEach != nil {
+		before, after = s.WrapEach(i, up)
+	}
+
+	for _, q := range []string{before, up, after} {
+		if q = strings.TrimSpace(q); q == "" {
+			continue
+		}
+		b.WriteString(q)
+		// Terminate the last statement so that it does not run into the
+		// next one, on its own line in case it ends with a comment.
+		if !strings.HasSuffix(strings.TrimSpace(StripComments(q)), ";") {
+			b.WriteString("\n;")
+		}
+		b.WriteByte('\n')
+	}
+
+	b.WriteByte('\n')
+	return nil
+}
+
+// writeSetVersion writes the statement that records that the first v versions
+// are applied.
+func (s *Schema) writeSetVersion(b *strings.Builder, v int) error {
+	numbers, err := s.numbers()
+	if err != nil {
+		return err
+	}
+
+	stored, err := storedVersion(v, numbers)
+	if err != nil {
+		return err
+	}
+
+	if store, ok := s.store.(PragmaStore); ok && s.SetVersion == nil {
+		fmt.Fprintf(b, "PRAGMA %s = %d;\n", store.Name, stored)
+	} else {
+		fmt.Fprintf(b, "-- Set the version to %d.\n", stored)
+	}
+
+	return nil
+}
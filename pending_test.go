@@ -0,0 +1,123 @@
+package lazymigrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWritePending(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE b (id INTEGER) -- no semicolon\n" +
+		"-- migrate\n" +
+		"CREATE TABLE c (id INTEGER);\n" +
+		"INSERT INTO c VALUES (1);\n" +
+		"-- DOWN --\n" +
+		"DROP TABLE c;"
+
+	tests := []struct {
+		name  string
+		v     int
+		setup func(*Schema)
+		want  string
+	}{
+		{
+			name: "none pending",
+			v:    3,
+			want: "",
+		},
+		{
+			name: "ahead",
+			v:    5,
+			want: "",
+		},
+		{
+			name: "some pending",
+			v:    2,
+			want: "" +
+				"-- Version 3\n" +
+				"CREATE TABLE c (id INTEGER);\n" +
+				"INSERT INTO c VALUES (1);\n" +
+				"\n" +
+				"PRAGMA user_version = 3;\n",
+		},
+		{
+			name: "all pending",
+			v:    0,
+			want: "" +
+				"-- Version 1\n" +
+				"CREATE TABLE a (id INTEGER);\n" +
+				"\n" +
+				"-- Version 2\n" +
+				"CREATE TABLE b (id INTEGER) -- no semicolon\n" +
+				";\n" +
+				"\n" +
+				"-- Version 3\n" +
+				"CREATE TABLE c (id INTEGER);\n" +
+				"INSERT INTO c VALUES (1);\n" +
+				"\n" +
+				"PRAGMA user_version = 3;\n",
+		},
+		{
+			name: "guard and wrap",
+			v:    1,
+			setup: func(s *Schema) {
+				s.Guard = func(index int, sql string) (bool, error) {
+					return index != 1, nil
+				}
+				s.WrapEach = func(index int, sql string) (before, after string) {
+					return "SELECT 'before';", "SELECT 'after'"
+				}
+			},
+			want: "" +
+				"-- Version 2\n" +
+				"-- Skipped, since Guard returned false.\n" +
+				"\n" +
+				"-- Version 3\n" +
+				"SELECT 'before';\n" +
+				"CREATE TABLE c (id INTEGER);\n" +
+				"INSERT INTO c VALUES (1);\n" +
+				"SELECT 'after'\n" +
+				";\n" +
+				"\n" +
+				"PRAGMA user_version = 3;\n",
+		},
+		{
+			name: "SetVersion",
+			v:    2,
+			setup: func(s *Schema) {
+				s.SetVersion = func(ctx context.Context, q DBTX, v int) error { return nil }
+			},
+			want: "" +
+				"-- Version 3\n" +
+				"CREATE TABLE c (id INTEGER);\n" +
+				"INSERT INTO c VALUES (1);\n" +
+				"\n" +
+				"-- Set the version to 3.\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, test.v)
+
+			s := NewSchemaWithMagic(schema, testMagic)
+			if test.setup != nil {
+				test.setup(s)
+			}
+
+			var b strings.Builder
+			if err := s.WritePending(context.Background(), db, &b); err != nil {
+				t.Fatal("cannot write pending migrations:", err)
+			}
+			if got := b.String(); got != test.want {
+				t.Errorf("WritePending() wrote:\n%s\nwant:\n%s", got, test.want)
+			}
+
+			if stmts := f.statements(); len(stmts) != 0 {
+				t.Errorf("executed %q, want nothing", stmts)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Progress is the progress of a migration, as reported by
+// [Schema.MigrateWithProgress].
+type Progress struct {
+	// Current is the number of versions of the schema that are applied, which
+	// is also the index of the version about to be applied.
+	Current int
+	// Total is the number of versions in the schema.
+	Total int
+}
+
+// MigrateWithProgress is like [Schema.Migrate], but it sends the progress on
+// ch just before each version is applied, and closes ch when migrating is
+// done, whether it failed or not. Sends do not block: if ch is full, the
+// progress is dropped, so a slow receiver cannot stall the migration. ch must
+// not be nil, and it must not be closed or sent to by anything else.
+func (s *Schema) MigrateWithProgress(ctx context.Context, db *sql.DB, ch chan<- Progress) error {
+	if ch == nil {
+		return errors.New("cannot report progress on a nil channel")
+	}
+	defer close(ch)
+
+	total := s.VersionCount()
+
+	// Work on a copy so that the hook is not visible to concurrent
+	// migrations with the same Schema.
+	cp := *s
+	cp.BeforeVersion = func(ctx context.Context, index int, sql string) error {
+		select {
+		case ch <- Progress{Current: index, Total: total}:
+		default:
+		}
+		if s.BeforeVersion != nil {
+			return s.BeforeVersion(ctx, index, sql)
+		}
+		return nil
+	}
+
+	return cp.Migrate(ctx, db)
+}
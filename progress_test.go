@@ -0,0 +1,45 @@
+package lazymigrate
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMigrateWithProgress(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE b (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE c (id INTEGER);"
+
+	_, db := newFakeDB(t, 1)
+	s := NewSchemaWithMagic(schema, testMagic)
+
+	ch := make(chan Progress, 3)
+	if err := s.MigrateWithProgress(context.Background(), db, ch); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	var got []Progress
+	for p := range ch {
+		got = append(got, p)
+	}
+
+	want := []Progress{{Current: 1, Total: 3}, {Current: 2, Total: 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got progress %v, want %v", got, want)
+	}
+}
+
+func TestMigrateWithProgressNil(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+
+	if err := s.MigrateWithProgress(context.Background(), db, nil); err == nil {
+		t.Fatal("MigrateWithProgress() with a nil channel succeeded")
+	}
+	if stmts := f.statements(); len(stmts) != 0 {
+		t.Errorf("executed %q, want nothing", stmts)
+	}
+}
@@ -0,0 +1,47 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ResumeNoTx is like [Schema.MigrateNoTx], but it is meant for resuming after
+// MigrateNoTx failed partway through a version. The statements of that
+// version that ran before the failure have already taken effect, so running
+// them again usually fails because the objects they create already exist.
+//
+// ResumeNoTx therefore skips statements of the first pending version that fail
+// with such an error, like "table users already exists" or "duplicate column
+// name", and applies the rest of the versions normally. Only errors about
+// existing objects are skipped, so statements such as INSERT or UPDATE that
+// ran before the failure are run again, and must be safe to repeat.
+func (s *Schema) ResumeNoTx(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if err := s.execPreStatements(ctx, conn); err != nil {
+		return err
+	}
+
+	v, latest, err := s.currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	cp := *s
+	cp.resumeIndex = v + 1
+	return cp.applyVersions(ctx, conn, v, latest)
+}
+
+// isAlreadyExists returns true if err is SQLite or PostgreSQL reporting that
+// the object being created already exists.
+func isAlreadyExists(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "already exists") ||
+		strings.Contains(msg, "duplicate column name")
+}
@@ -0,0 +1,78 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestIsAlreadyExists(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		// SQLite
+		{"table users already exists", true},
+		{"index users_name already exists", true},
+		{"trigger users_updated already exists", true},
+		{"view active_users already exists", true},
+		{"duplicate column name: email", true},
+		{"no such table: users", false},
+		{"UNIQUE constraint failed: users.id", false},
+		{`near "CREAT": syntax error`, false},
+		{"database is locked", false},
+
+		// PostgreSQL
+		{`pq: relation "users" already exists`, true},
+		{`ERROR: column "email" of relation "users" already exists (SQLSTATE 42701)`, true},
+		{`pq: relation "users" does not exist`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			if got := isAlreadyExists(errors.New(test.msg)); got != test.want {
+				t.Errorf("isAlreadyExists(%q) = %v, want %v", test.msg, got, test.want)
+			}
+		})
+	}
+
+	// The error may be wrapped, such as by database/sql.
+	err := fmt.Errorf("cannot exec: %w", errors.New("table users already exists"))
+	if !isAlreadyExists(err) {
+		t.Errorf("isAlreadyExists(%q) = false, want true", err)
+	}
+}
+
+func TestResumeNoTx(t *testing.T) {
+	const schema = "" +
+		"CREATE TABLE a (id INTEGER);\nCREATE TABLE b (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE c (id INTEGER);"
+
+	// Only the first pending version may have been applied partially.
+	tests := []struct {
+		name   string
+		failed string
+		ok     bool
+	}{
+		{"first pending version", "CREATE TABLE a (id INTEGER);", true},
+		{"later version", "CREATE TABLE c (id INTEGER);", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+			f.errs[test.failed] = errors.New("table already exists")
+
+			err := NewSchemaWithMagic(schema, testMagic).ResumeNoTx(context.Background(), db)
+			if ok := err == nil; ok != test.ok {
+				t.Fatalf("ResumeNoTx() = %v, want success = %v", err, test.ok)
+			}
+			if test.ok && !slices.Contains(f.statements(), "PRAGMA user_version = 2") {
+				t.Errorf("executed %q, want the version to be set to 2", f.statements())
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLite result codes for a busy or locked database. Extended result codes
+// keep these in their lowest byte.
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// isBusy returns true if err is SQLite reporting that the database is busy or
+// locked by another connection. Drivers that expose the result code through a
+// Code method are detected by it, and all others by the error message.
+func isBusy(err error) bool {
+	var coder interface{ Code() int }
+	if errors.As(err, &coder) {
+		code := coder.Code() & 0xFF
+		return code == sqliteBusy || code == sqliteLocked
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "database is busy") ||
+		strings.Contains(msg, "database table is locked") ||
+		strings.Contains(msg, "SQLITE_BUSY")
+}
+
+// retryBusy calls fn until it succeeds, fails with an error that is not
+// [isBusy], or BusyRetries retries have been made. The delay between retries
+// starts at BusyBackoff and doubles after each retry.
+func (s *Schema) retryBusy(ctx context.Context, fn func() error) error {
+	delay := s.BusyBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.BusyRetries || !isBusy(err) {
+			return err
+		}
+
+		s.logf("database is busy, retrying in %v (%d/%d): %v", delay, attempt+1, s.BusyRetries, err)
+
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			case <-t.C:
+			}
+			delay *= 2
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// codeError is an error with a Code method, like the errors of SQLite
+// drivers.
+type codeError int
+
+func (e codeError) Error() string { return fmt.Sprintf("code %d", int(e)) }
+func (e codeError) Code() int     { return int(e) }
+
+func TestIsBusy(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		busy bool
+	}{
+		{"busy code", codeError(5), true},
+		{"locked code", codeError(6), true},
+		{"extended busy code", codeError(5 | 2<<8), true},
+		{"other code", codeError(1), false},
+		{"wrapped code", fmt.Errorf("cannot begin: %w", codeError(5)), true},
+		{"locked message", errors.New("database is locked"), true},
+		{"table locked message", errors.New("database table is locked: a"), true},
+		{"busy name", errors.New("SQLITE_BUSY"), true},
+		{"other message", errors.New("no such table: a"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if busy := isBusy(test.err); busy != test.busy {
+				t.Errorf("isBusy(%v) = %v, want %v", test.err, busy, test.busy)
+			}
+		})
+	}
+}
+
+func TestMigrateRetriesBusy(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		fails   int
+		err     error
+		// attempts is the number of times the version is tried.
+		attempts int
+	}{
+		{
+			name:     "not busy",
+			retries:  2,
+			attempts: 1,
+		},
+		{
+			name:     "busy until the last retry",
+			retries:  2,
+			fails:    2,
+			attempts: 3,
+		},
+		{
+			name:     "busy for too long",
+			retries:  2,
+			fails:    3,
+			err:      codeError(sqliteBusy),
+			attempts: 3,
+		},
+		{
+			name:     "busy without retries",
+			fails:    1,
+			err:      codeError(sqliteBusy),
+			attempts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, db := newFakeDB(t, 0)
+
+			var attempts int
+			s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+			s.BusyRetries = test.retries
+			s.BeforeVersion = func(ctx context.Context, index int, sql string) error {
+				attempts++
+				if attempts <= test.fails {
+					return codeError(sqliteBusy)
+				}
+				return nil
+			}
+
+			err := s.Migrate(context.Background(), db)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Migrate() = %v, want %v", err, test.err)
+			}
+			if attempts != test.attempts {
+				t.Errorf("version was tried %d times, want %d", attempts, test.attempts)
+			}
+		})
+	}
+}
+
+func TestMigrateRetriesOnlyBusy(t *testing.T) {
+	_, db := newFakeDB(t, 0)
+
+	failure := errors.New("no such table: b")
+
+	var attempts int
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	s.BusyRetries = 3
+	s.BeforeVersion = func(ctx context.Context, index int, sql string) error {
+		attempts++
+		return failure
+	}
+
+	if err := s.Migrate(context.Background(), db); !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+	if attempts != 1 {
+		t.Errorf("version was tried %d times, want 1", attempts)
+	}
+}
+
+func TestMigrateRetriesCanceled(t *testing.T) {
+	_, db := newFakeDB(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	s.BusyRetries = 1
+	s.BusyBackoff = time.Hour
+	s.BeforeVersion = func(ctx context.Context, index int, sql string) error {
+		cancel()
+		return codeError(sqliteBusy)
+	}
+
+	if err := s.Migrate(ctx, db); !isBusy(err) {
+		t.Fatalf("Migrate() = %v, want the busy error", err)
+	}
+}
@@ -0,0 +1,215 @@
+package lazymigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// statement is a single SQL statement within a version.
+type statement struct {
+	sql string
+	// offset is the byte offset of the statement within the version.
+	offset int
+}
+
+// SplitStatements splits the given SQL into individual statements, the same
+// way that each version is split before its statements are executed one by
+// one. Semicolons inside string literals, quoted identifiers, comments,
+// BEGIN ... END trigger bodies and PostgreSQL dollar-quoted strings do not end
+// a statement. A dollar tag such as $a$ that is not closed later in sql is
+// taken as an SQLite parameter instead of the start of a dollar-quoted string.
+// Each statement keeps its terminating semicolon, if any, and comments between
+// statements are dropped. An error is returned for an unterminated string
+// literal, quoted identifier or trigger body.
+func SplitStatements(sql string) ([]string, error) {
+	stmts, err := splitStatements(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		strs[i] = stmt.sql
+	}
+	return strs, nil
+}
+
+// splitStatements splits the given SQL into individual statements on
+// semicolons. Semicolons inside string literals, quoted identifiers, comments,
+// trigger bodies and PostgreSQL dollar-quoted strings do not end a statement.
+// Comments between statements are dropped, and statements that are empty are
+// skipped.
+func splitStatements(src string) ([]statement, error) {
+	var stmts []statement
+
+	start := -1 // start of the current statement, or -1 if there is none yet
+	var words []string
+	var trigger bool
+	var depth int
+
+	flush := func(end int) {
+		// A lone semicolon is an empty statement.
+		if start != -1 && src[start:end] != ";" {
+			stmts = append(stmts, statement{
+				sql:    strings.TrimSpace(src[start:end]),
+				offset: start,
+			})
+		}
+		start = -1
+		words = words[:0]
+		trigger = false
+		depth = 0
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			if j := strings.IndexByte(src[i:], '\n'); j != -1 {
+				i += j + 1
+			} else {
+				i = len(src)
+			}
+			continue
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			// SQLite allows a block comment to be terminated by the end of
+			// the input.
+			if j := strings.Index(src[i+2:], "*/"); j != -1 {
+				i += 2 + j + 2
+			} else {
+				i = len(src)
+			}
+			continue
+		case isSpace(c):
+			i++
+			continue
+		}
+
+		if start == -1 {
+			start = i
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := skipQuoted(src, i)
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated quote %c at offset %d", c, i)
+			}
+			i = j
+		case c == '$' && dollarTag(src[i:]) != "":
+			// PostgreSQL dollar-quoted string, such as a function body. A
+			// tag that is never closed is an SQLite parameter like $a$
+			// instead.
+			tag := dollarTag(src[i:])
+			if j := strings.Index(src[i+len(tag):], tag); j != -1 {
+				i += len(tag) + j + len(tag)
+			} else {
+				i += len(tag)
+			}
+		case c == '[':
+			j := strings.IndexByte(src[i:], ']')
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated quote [ at offset %d", i)
+			}
+			i += j + 1
+		case c == ';':
+			i++
+			if trigger && depth > 0 {
+				continue
+			}
+			flush(i)
+		case isWordStart(c):
+			j := i + 1
+			for j < len(src) && isWordPart(src[j]) {
+				j++
+			}
+
+			word := strings.ToUpper(src[i:j])
+			if len(words) < 3 {
+				words = append(words, word)
+				trigger = isCreateTrigger(words)
+			}
+
+			// Trigger bodies are wrapped in BEGIN and END and may contain
+			// semicolons. CASE expressions also end with END, so they must be
+			// counted as well.
+			if trigger {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					depth--
+				}
+			}
+
+			i = j
+		default:
+			i++
+		}
+	}
+
+	if trigger && depth > 0 {
+		return nil, fmt.Errorf("unterminated trigger body at offset %d", start)
+	}
+
+	flush(len(src))
+	return stmts, nil
+}
+
+// skipQuoted returns the offset just after the quoted string starting at i, or
+// -1 if it is not terminated. A quote is escaped by doubling it.
+func skipQuoted(src string, i int) int {
+	q := src[i]
+	for j := i + 1; j < len(src); j++ {
+		if src[j] != q {
+			continue
+		}
+		if j+1 < len(src) && src[j+1] == q {
+			j++
+			continue
+		}
+		return j + 1
+	}
+	return -1
+}
+
+// dollarTag returns the PostgreSQL dollar quote tag, such as "$$" or
+// "$body$", at the start of src, or an empty string if there is none.
+func dollarTag(src string) string {
+	for j := 1; j < len(src); j++ {
+		c := src[j]
+		if c == '$' {
+			return src[:j+1]
+		}
+		if !isWordStart(c) && !(j > 1 && '0' <= c && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isCreateTrigger(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	switch words[1] {
+	case "TRIGGER":
+		return true
+	case "TEMP", "TEMPORARY":
+		return len(words) > 2 && words[2] == "TRIGGER"
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || ('0' <= c && c <= '9') || c == '$'
+}
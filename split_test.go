@@ -0,0 +1,152 @@
+package lazymigrate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "simple",
+			sql:  "CREATE TABLE a (id INTEGER);\nCREATE TABLE b (id INTEGER);",
+			want: []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"},
+		},
+		{
+			name: "no trailing semicolon",
+			sql:  "SELECT 1; SELECT 2",
+			want: []string{"SELECT 1;", "SELECT 2"},
+		},
+		{
+			name: "empty statements",
+			sql:  ";; SELECT 1;;",
+			want: []string{"SELECT 1;"},
+		},
+		{
+			name: "semicolon in string",
+			sql:  "INSERT INTO t VALUES ('a;b'); SELECT 'it''s;';",
+			want: []string{"INSERT INTO t VALUES ('a;b');", "SELECT 'it''s;';"},
+		},
+		{
+			name: "semicolon in quoted identifiers",
+			sql:  `CREATE TABLE "a;b" ("c;d" TEXT, ` + "`e;f`" + ` TEXT); SELECT 1;`,
+			want: []string{`CREATE TABLE "a;b" ("c;d" TEXT, ` + "`e;f`" + ` TEXT);`, "SELECT 1;"},
+		},
+		{
+			name: "semicolon in brackets",
+			sql:  "CREATE TABLE [a;b] ([it's] TEXT); SELECT 1;",
+			want: []string{"CREATE TABLE [a;b] ([it's] TEXT);", "SELECT 1;"},
+		},
+		{
+			name: "semicolon in comments",
+			sql:  "SELECT 1; -- a; b\n/* c; d */ SELECT 2;",
+			want: []string{"SELECT 1;", "SELECT 2;"},
+		},
+		{
+			name: "trigger",
+			sql: "CREATE TRIGGER t AFTER INSERT ON a BEGIN\n" +
+				"  INSERT INTO b VALUES (1);\n" +
+				"  INSERT INTO b VALUES (2);\n" +
+				"END;\n" +
+				"SELECT 1;",
+			want: []string{
+				"CREATE TRIGGER t AFTER INSERT ON a BEGIN\n" +
+					"  INSERT INTO b VALUES (1);\n" +
+					"  INSERT INTO b VALUES (2);\n" +
+					"END;",
+				"SELECT 1;",
+			},
+		},
+		{
+			name: "temporary trigger with CASE",
+			sql: "CREATE TEMP TRIGGER t AFTER INSERT ON a BEGIN\n" +
+				"  INSERT INTO b VALUES (CASE WHEN new.x THEN 1 ELSE 2 END);\n" +
+				"  SELECT CASE new.y WHEN 1 THEN 'a;' END;\n" +
+				"END;\n" +
+				"SELECT 1;",
+			want: []string{
+				"CREATE TEMP TRIGGER t AFTER INSERT ON a BEGIN\n" +
+					"  INSERT INTO b VALUES (CASE WHEN new.x THEN 1 ELSE 2 END);\n" +
+					"  SELECT CASE new.y WHEN 1 THEN 'a;' END;\n" +
+					"END;",
+				"SELECT 1;",
+			},
+		},
+		{
+			name: "CASE outside of a trigger",
+			sql:  "SELECT CASE WHEN 1 THEN 2 END; SELECT 3;",
+			want: []string{"SELECT CASE WHEN 1 THEN 2 END;", "SELECT 3;"},
+		},
+		{
+			name: "dollar quotes",
+			sql: "CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END; $$ LANGUAGE plpgsql;\n" +
+				"COMMENT ON TABLE u IS $body$user's; table$body$;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS void AS $$ BEGIN PERFORM 1; END; $$ LANGUAGE plpgsql;",
+				"COMMENT ON TABLE u IS $body$user's; table$body$;",
+			},
+		},
+		{
+			name: "dollar parameter",
+			sql:  "SELECT x FROM t WHERE y = $a$; SELECT 3;",
+			want: []string{"SELECT x FROM t WHERE y = $a$;", "SELECT 3;"},
+		},
+		{
+			name: "numbered parameter",
+			sql:  "SELECT $1; SELECT $2;",
+			want: []string{"SELECT $1;", "SELECT $2;"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := SplitStatements(test.sql)
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("SplitStatements() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsUnterminated(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+	}{
+		{"single quote", "SELECT 'a; SELECT 2;"},
+		{"double quote", `SELECT "a; SELECT 2;`},
+		{"backtick", "SELECT `a; SELECT 2;"},
+		{"bracket", "SELECT [a; SELECT 2;"},
+		{"trigger body", "CREATE TRIGGER t AFTER INSERT ON a BEGIN SELECT 1;"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := SplitStatements(test.sql); err == nil {
+				t.Errorf("SplitStatements() = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsOffsets(t *testing.T) {
+	src := "SELECT 1;\n  -- comment\n  SELECT 2;"
+
+	stmts, err := splitStatements(src)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	for _, stmt := range stmts {
+		if got := src[stmt.offset : stmt.offset+len(stmt.sql)]; got != stmt.sql {
+			t.Errorf("statement %q is at offset %d, which has %q", stmt.sql, stmt.offset, got)
+		}
+	}
+}
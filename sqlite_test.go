@@ -0,0 +1,1477 @@
+package lazymigrate_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"libdb.so/lazymigrate"
+	"libdb.so/lazymigrate/lazymigratetest"
+)
+
+// openFileDB opens an SQLite database in a new file, so that it can be shared
+// by several connections and *sql.DB values, like separate processes would.
+func openFileDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatal("cannot open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// loggingSchema returns a schema of n versions, each of which creates a table
+// and records that it was applied in the applied table.
+func loggingSchema(n int) string {
+	versions := make([]string, n)
+	versions[0] = "CREATE TABLE applied (version INTEGER NOT NULL);\n"
+	for i := range versions {
+		versions[i] += fmt.Sprintf(
+			"CREATE TABLE t%[1]d (id INTEGER PRIMARY KEY);\n"+
+				"INSERT INTO applied (version) VALUES (%[1]d);", i)
+	}
+
+	schema, err := lazymigrate.Join(versions...)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// checkAppliedOnce checks that each of the n versions of a [loggingSchema] was
+// applied exactly once, and that the database is at version n.
+func checkAppliedOnce(t *testing.T, db *sql.DB, n int) {
+	t.Helper()
+
+	rows, err := db.Query("SELECT version, COUNT(*) FROM applied GROUP BY version ORDER BY version")
+	if err != nil {
+		t.Fatal("cannot query applied versions:", err)
+	}
+	defer rows.Close()
+
+	var versions int
+	for rows.Next() {
+		var version, count int
+		if err := rows.Scan(&version, &count); err != nil {
+			t.Fatal("cannot scan applied version:", err)
+		}
+		if version != versions {
+			t.Errorf("version %d was never applied", versions)
+		}
+		if count != 1 {
+			t.Errorf("version %d was applied %d times", version, count)
+		}
+		versions = version + 1
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal("cannot query applied versions:", err)
+	}
+
+	if versions != n {
+		t.Errorf("%d versions were applied, want %d", versions, n)
+	}
+
+	var v int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		t.Fatal("cannot read user_version:", err)
+	}
+	if v != n {
+		t.Errorf("user_version = %d, want %d", v, n)
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	const versions = 5
+	const instances = 8
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	schema := lazymigrate.NewSchema(loggingSchema(versions))
+
+	dbs := make([]*sql.DB, instances)
+	for i := range dbs {
+		dbs[i] = openFileDB(t, path)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, instances)
+	for i, db := range dbs {
+		i, db := i, db
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = schema.Migrate(context.Background(), db)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("instance %d cannot migrate: %v", i, err)
+		}
+	}
+
+	checkAppliedOnce(t, dbs[0], versions)
+}
+
+func TestMigrateStress(t *testing.T) {
+	const versions = 3
+	const instances = 16
+
+	rounds := 20
+	if testing.Short() {
+		rounds = 2
+	}
+
+	schema := lazymigrate.NewSchema(loggingSchema(versions))
+
+	for round := 0; round < rounds; round++ {
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("test%d.db", round))
+
+		// Half of the instances share a pool, so that the same *sql.DB also
+		// migrates concurrently with itself.
+		shared := openFileDB(t, path)
+
+		var wg sync.WaitGroup
+		errs := make([]error, instances)
+		for i := 0; i < instances; i++ {
+			db := shared
+			if i%2 == 0 {
+				db = openFileDB(t, path)
+			}
+
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = schema.Migrate(context.Background(), db)
+			}()
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("round %d: instance %d cannot migrate: %v", round, i, err)
+			}
+		}
+
+		checkAppliedOnce(t, shared, versions)
+	}
+}
+
+func TestMigrateEmptyVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"double delimiter", "CREATE TABLE a (id INTEGER);\n-- migrate\n-- migrate\nCREATE TABLE b (id INTEGER);"},
+		{"trailing delimiter", "CREATE TABLE a (id INTEGER);\n-- migrate\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+			err := lazymigrate.NewSchemaWithMagic(test.schema, "-- migrate").Migrate(context.Background(), db)
+			if !errors.Is(err, lazymigrate.ErrEmptyVersion) {
+				t.Fatalf("Migrate() = %v, want an error wrapping ErrEmptyVersion", err)
+			}
+
+			// The versions before the empty one must be rolled back as well
+			// instead of being counted as applied.
+			var v int
+			if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+				t.Fatal("cannot read user_version:", err)
+			}
+			if v != 0 {
+				t.Errorf("user_version = %d, want 0", v)
+			}
+		})
+	}
+}
+
+// testLogger is a [lazymigrate.Logger] that collects the logged lines.
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestStepLogsTotal(t *testing.T) {
+	db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+	var logger testLogger
+	schema := lazymigrate.NewSchema(loggingSchema(3))
+	schema.Logger = &logger
+
+	if _, err := schema.Step(context.Background(), db); err != nil {
+		t.Fatal("cannot step:", err)
+	}
+	if _, err := schema.Step(context.Background(), db); err != nil {
+		t.Fatal("cannot step:", err)
+	}
+
+	want := []string{
+		"applying version 1/3",
+		"done",
+		"applying version 2/3",
+		"done",
+	}
+	if !slices.Equal(logger.lines, want) {
+		t.Errorf("logged %q, want %q", logger.lines, want)
+	}
+}
+
+func TestPreStatementsAttach(t *testing.T) {
+	schema := lazymigrate.NewSchema(loggingSchema(1) + "\n" + lazymigrate.Delimiter + "\n" +
+		"CREATE TABLE aux.events (id INTEGER PRIMARY KEY, name TEXT NOT NULL);\n" +
+		"INSERT INTO aux.events (name) VALUES ('migrated');")
+	schema.PreStatements = []string{"ATTACH DATABASE ':memory:' AS aux"}
+
+	// The database is limited to one connection, so the database stays
+	// attached to it after migrating.
+	db := lazymigratetest.NewDBWithSchema(t, schema)
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM aux.events").Scan(&name); err != nil {
+		t.Fatal("cannot query the attached database:", err)
+	}
+	if name != "migrated" {
+		t.Errorf("event name = %q, want %q", name, "migrated")
+	}
+
+	// The version belongs to the main database, not the attached one.
+	var v int
+	if err := db.QueryRow("PRAGMA main.user_version").Scan(&v); err != nil {
+		t.Fatal("cannot read user_version:", err)
+	}
+	if v != 2 {
+		t.Errorf("user_version = %d, want 2", v)
+	}
+}
+
+func TestAfterCommit(t *testing.T) {
+	failing, err := lazymigrate.Join(
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);",
+		"INSERT INTO missing VALUES (1);",
+	)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+
+	tests := []struct {
+		name    string
+		schema  string
+		migrate func(*lazymigrate.Schema, *sql.DB) error
+		want    []int
+		fails   bool
+	}{
+		{
+			name:   "Migrate",
+			schema: loggingSchema(3),
+			migrate: func(s *lazymigrate.Schema, db *sql.DB) error {
+				return s.Migrate(context.Background(), db)
+			},
+			want: []int{3},
+		},
+		{
+			name:   "MigrateFrom",
+			schema: loggingSchema(3),
+			migrate: func(s *lazymigrate.Schema, db *sql.DB) error {
+				return s.MigrateFrom(context.Background(), db, 0)
+			},
+			want: []int{3},
+		},
+		{
+			name:   "MigrateIncremental",
+			schema: loggingSchema(3),
+			migrate: func(s *lazymigrate.Schema, db *sql.DB) error {
+				return s.MigrateIncremental(context.Background(), db)
+			},
+			want: []int{3},
+		},
+		{
+			name:   "MigrateIncremental partially",
+			schema: failing,
+			migrate: func(s *lazymigrate.Schema, db *sql.DB) error {
+				return s.MigrateIncremental(context.Background(), db)
+			},
+			want:  []int{2},
+			fails: true,
+		},
+		{
+			name:   "Migrate failing",
+			schema: failing,
+			migrate: func(s *lazymigrate.Schema, db *sql.DB) error {
+				return s.Migrate(context.Background(), db)
+			},
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+			var calls []int
+			s := lazymigrate.NewSchema(test.schema)
+			s.AfterCommit = func(applied int) { calls = append(calls, applied) }
+
+			if err := test.migrate(s, db); (err != nil) != test.fails {
+				t.Fatalf("migrating = %v, want failure = %v", err, test.fails)
+			}
+			if !slices.Equal(calls, test.want) {
+				t.Errorf("AfterCommit called with %v, want %v", calls, test.want)
+			}
+		})
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	versions := []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);",
+		"CREATE INDEX users_name ON users (name);",
+		"ALTER TABLE users ADD COLUMN email TEXT;",
+	}
+
+	schema, err := lazymigrate.Join(versions...)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+
+	tests := []struct {
+		name     string
+		existing string
+		want     int
+		err      error
+	}{
+		{
+			name:     "matching tables",
+			existing: strings.Join(versions, "\n"),
+			want:     3,
+		},
+		{
+			name: "different formatting",
+			existing: "create table USERS (id integer primary key, name text not null);\n" +
+				"CREATE INDEX users_name ON users(name);\n" +
+				"ALTER TABLE users ADD COLUMN email TEXT;",
+			want: 3,
+		},
+		{
+			name:     "missing column",
+			existing: strings.Join(versions[:2], "\n"),
+			err:      lazymigrate.ErrAdoptMismatch,
+		},
+		{
+			name:     "extra table",
+			existing: strings.Join(versions, "\n") + "\nCREATE TABLE other (id INTEGER);",
+			err:      lazymigrate.ErrAdoptMismatch,
+		},
+		{
+			name: "no tables",
+			want: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+			if test.existing != "" {
+				if _, err := db.Exec(test.existing); err != nil {
+					t.Fatal("cannot create existing tables:", err)
+				}
+			}
+
+			s := lazymigrate.NewSchema(schema)
+			s.Adopt = true
+
+			err := s.Migrate(context.Background(), db)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Migrate() = %v, want %v", err, test.err)
+			}
+
+			var v int
+			if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+				t.Fatal("cannot read user_version:", err)
+			}
+			if v != test.want {
+				t.Errorf("user_version = %d, want %d", v, test.want)
+			}
+		})
+	}
+}
+
+func TestAdoptPreStatements(t *testing.T) {
+	dir := t.TempDir()
+
+	db := openFileDB(t, filepath.Join(dir, "test.db"))
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY);"); err != nil {
+		t.Fatal("cannot create existing tables:", err)
+	}
+
+	s := lazymigrate.NewSchema("CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	s.Adopt = true
+	s.PreStatements = []string{
+		fmt.Sprintf("ATTACH DATABASE '%s' AS aux", filepath.Join(dir, "aux.db")),
+	}
+
+	// The database would already be attached if the PreStatements ran twice.
+	if err := s.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	var v int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		t.Fatal("cannot read user_version:", err)
+	}
+	if v != 1 {
+		t.Errorf("user_version = %d, want 1", v)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	versions := []string{
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);",
+		"CREATE TABLE c (id INTEGER);",
+	}
+
+	migrate := func(t *testing.T, db *sql.DB, versions ...string) error {
+		t.Helper()
+
+		schema, err := lazymigrate.Join(versions...)
+		if err != nil {
+			t.Fatal("cannot join versions:", err)
+		}
+
+		s := lazymigrate.NewSchema(schema)
+		s.VerifyChain = true
+		return s.Migrate(context.Background(), db)
+	}
+
+	t.Run("append", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+		// Each migration carries the chain forward from the hash recorded by
+		// the one before, and the next one verifies it from the start.
+		for n := 1; n <= len(versions); n++ {
+			if err := migrate(t, db, versions[:n]...); err != nil {
+				t.Fatalf("cannot migrate to version %d: %v", n, err)
+			}
+		}
+		if err := migrate(t, db, versions...); err != nil {
+			t.Fatal("cannot migrate again:", err)
+		}
+	})
+
+	t.Run("baseline", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		if _, err := db.Exec(versions[0]); err != nil {
+			t.Fatal("cannot create existing table:", err)
+		}
+
+		s := lazymigrate.NewSchema(versions[0])
+		if err := s.Baseline(context.Background(), db, 1); err != nil {
+			t.Fatal("cannot baseline:", err)
+		}
+
+		// The first version has no recorded hash to carry forward.
+		if err := migrate(t, db, versions...); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+		if err := migrate(t, db, versions...); err != nil {
+			t.Fatal("cannot migrate again:", err)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		versions []string
+		diverged int
+	}{
+		{
+			name:     "inserted",
+			versions: []string{versions[0], "CREATE TABLE x (id INTEGER);", versions[1], versions[2]},
+			diverged: 1,
+		},
+		{
+			name:     "swapped",
+			versions: []string{versions[0], versions[2], versions[1]},
+			diverged: 1,
+		},
+		{
+			name:     "changed",
+			versions: []string{versions[0], versions[1], "CREATE TABLE c (id INTEGER, name TEXT);"},
+			diverged: 2,
+		},
+		{
+			name:     "removed",
+			versions: []string{versions[1], versions[2], "CREATE TABLE d (id INTEGER);"},
+			diverged: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+			if err := migrate(t, db, versions...); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			err := migrate(t, db, test.versions...)
+			if !errors.Is(err, lazymigrate.ErrVersionsReordered) {
+				t.Fatalf("Migrate() = %v, want %v", err, lazymigrate.ErrVersionsReordered)
+			}
+			if want := fmt.Sprintf("version %d (from 0th)", test.diverged); !strings.Contains(err.Error(), want) {
+				t.Errorf("Migrate() = %v, want it to name %s", err, want)
+			}
+		})
+	}
+}
+
+func TestNumberedVersionsChecksums(t *testing.T) {
+	schema, err := lazymigrate.Join(
+		"-- version 1\nCREATE TABLE a (id INTEGER);",
+		"-- version 5\nCREATE TABLE b (id INTEGER);",
+	)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+
+	// The checksums are keyed by position, so they cannot follow versions
+	// that are removed or renumbered.
+	for _, migrated := range []bool{false, true} {
+		t.Run(fmt.Sprintf("migrated=%v", migrated), func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+			s := lazymigrate.NewSchemaWithTable(schema, "version")
+			s.NumberedVersions = true
+			if migrated {
+				if err := s.Migrate(context.Background(), db); err != nil {
+					t.Fatal("cannot migrate without checksums:", err)
+				}
+			}
+
+			s.VerifyChecksums = true
+			err := s.Migrate(context.Background(), db)
+			if err == nil || !strings.Contains(err.Error(), "checksums cannot be verified with numbered versions") {
+				t.Fatalf("Migrate() = %v, want an error about checksums", err)
+			}
+		})
+	}
+}
+
+func TestMigrateDownTo(t *testing.T) {
+	versions := []string{
+		"CREATE TABLE a (id INTEGER);\n" + lazymigrate.DownMarker + "\nDROP TABLE a;",
+		"CREATE TABLE b (id INTEGER);\n" + lazymigrate.DownMarker + "\nDROP TABLE b;",
+		"CREATE TABLE c (id INTEGER);\n" + lazymigrate.DownMarker + "\nDROP TABLE c;",
+	}
+
+	newSchema := func(t *testing.T, versions ...string) *lazymigrate.Schema {
+		t.Helper()
+		schema, err := lazymigrate.Join(versions...)
+		if err != nil {
+			t.Fatal("cannot join versions:", err)
+		}
+		return lazymigrate.NewSchema(schema)
+	}
+
+	// checkTables checks the version of the database and which of the tables
+	// a, b and c exist.
+	checkTables := func(t *testing.T, db *sql.DB, v int, want ...string) {
+		t.Helper()
+
+		var got int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&got); err != nil {
+			t.Fatal("cannot read user_version:", err)
+		}
+		if got != v {
+			t.Errorf("user_version = %d, want %d", got, v)
+		}
+
+		var tables []string
+		rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('a', 'b', 'c') ORDER BY name")
+		if err != nil {
+			t.Fatal("cannot query tables:", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal("cannot scan table:", err)
+			}
+			tables = append(tables, name)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal("cannot query tables:", err)
+		}
+		if !slices.Equal(tables, want) {
+			t.Errorf("tables = %q, want %q", tables, want)
+		}
+	}
+
+	ctx := context.Background()
+
+	t.Run("round trip", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		s := newSchema(t, versions...)
+
+		if err := s.Migrate(ctx, db); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+		checkTables(t, db, 3, "a", "b", "c")
+
+		if err := s.MigrateDownTo(ctx, db, 1); err != nil {
+			t.Fatal("cannot migrate down:", err)
+		}
+		checkTables(t, db, 1, "a")
+
+		if err := s.Migrate(ctx, db); err != nil {
+			t.Fatal("cannot migrate up again:", err)
+		}
+		checkTables(t, db, 3, "a", "b", "c")
+
+		if err := s.MigrateDownTo(ctx, db, 0); err != nil {
+			t.Fatal("cannot migrate down to 0:", err)
+		}
+		checkTables(t, db, 0)
+	})
+
+	t.Run("missing down section", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		s := newSchema(t, versions[0], "CREATE TABLE b (id INTEGER);", versions[2])
+
+		if err := s.Migrate(ctx, db); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		// Version 2 could be undone, but nothing is undone since version 1
+		// cannot.
+		err := s.MigrateDownTo(ctx, db, 0)
+		if !errors.Is(err, lazymigrate.ErrNoDownMigration) {
+			t.Fatalf("MigrateDownTo() = %v, want %v", err, lazymigrate.ErrNoDownMigration)
+		}
+		checkTables(t, db, 3, "a", "b", "c")
+
+		// Undoing only the versions with a down section still works.
+		if err := s.MigrateDownTo(ctx, db, 2); err != nil {
+			t.Fatal("cannot migrate down to 2:", err)
+		}
+		checkTables(t, db, 2, "a", "b")
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		s := newSchema(t, versions...)
+
+		if err := s.MigrateTo(ctx, db, 2); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		for _, target := range []int{3, -1} {
+			if err := s.MigrateDownTo(ctx, db, target); err == nil {
+				t.Errorf("MigrateDownTo(%d) = nil, want an error", target)
+			}
+		}
+		checkTables(t, db, 2, "a", "b")
+
+		// Migrating down to the current version does nothing.
+		if err := s.MigrateDownTo(ctx, db, 2); err != nil {
+			t.Error("cannot migrate down to the current version:", err)
+		}
+		checkTables(t, db, 2, "a", "b")
+	})
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	versions := []string{
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);",
+	}
+
+	migrate := func(t *testing.T, db *sql.DB, verify bool, versions ...string) error {
+		t.Helper()
+
+		schema, err := lazymigrate.Join(versions...)
+		if err != nil {
+			t.Fatal("cannot join versions:", err)
+		}
+
+		s := lazymigrate.NewSchemaWithTable(schema, "version")
+		s.VerifyChecksums = verify
+		return s.Migrate(context.Background(), db)
+	}
+
+	t.Run("changed", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		if err := migrate(t, db, true, versions...); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		err := migrate(t, db, true, versions[0], "CREATE TABLE b (id INTEGER, name TEXT);")
+		if !errors.Is(err, lazymigrate.ErrChecksumMismatch) {
+			t.Fatalf("Migrate() = %v, want %v", err, lazymigrate.ErrChecksumMismatch)
+		}
+		if !strings.Contains(err.Error(), "version 1 (from 0th)") {
+			t.Errorf("Migrate() = %v, want it to name version 1", err)
+		}
+	})
+
+	t.Run("appended", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		if err := migrate(t, db, true, versions...); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		appended := append(versions[:2:2], "CREATE TABLE c (id INTEGER);")
+		if err := migrate(t, db, true, appended...); err != nil {
+			t.Fatal("cannot migrate an appended version:", err)
+		}
+		if err := migrate(t, db, true, appended...); err != nil {
+			t.Fatal("cannot migrate again:", err)
+		}
+	})
+
+	t.Run("no checksums recorded", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+		if err := migrate(t, db, false, versions...); err != nil {
+			t.Fatal("cannot migrate without checksums:", err)
+		}
+
+		// The versions applied without checksums are not checked, but the
+		// ones applied from now on are.
+		changed := []string{"CREATE TABLE a (id INTEGER, name TEXT);", versions[1], "CREATE TABLE c (id INTEGER);"}
+		if err := migrate(t, db, true, changed...); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		changed[2] = "CREATE TABLE c (id INTEGER, name TEXT);"
+		if err := migrate(t, db, true, changed...); !errors.Is(err, lazymigrate.ErrChecksumMismatch) {
+			t.Fatalf("Migrate() = %v, want %v", err, lazymigrate.ErrChecksumMismatch)
+		}
+	})
+
+	t.Run("table without checksum column", func(t *testing.T) {
+		db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+		// This is the version table of a TableStore from before checksums
+		// were supported.
+		if _, err := db.Exec(strings.Join(versions, "\n") + "\n" +
+			"CREATE TABLE version (version INTEGER NOT NULL, applied_at TEXT NOT NULL);\n" +
+			"INSERT INTO version VALUES (1, CURRENT_TIMESTAMP), (2, CURRENT_TIMESTAMP);",
+		); err != nil {
+			t.Fatal("cannot create existing tables:", err)
+		}
+
+		appended := append(versions[:2:2], "CREATE TABLE c (id INTEGER);")
+		if err := migrate(t, db, true, appended...); err != nil {
+			t.Fatal("cannot migrate:", err)
+		}
+
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM version WHERE checksum IS NOT NULL").Scan(&n); err != nil {
+			t.Fatal("cannot count checksums:", err)
+		}
+		if n != 1 {
+			t.Errorf("%d checksums recorded, want 1", n)
+		}
+	})
+}
+
+func TestCheckDrift(t *testing.T) {
+	s := lazymigrate.NewSchema(loggingSchema(2))
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		edit  string
+		drift bool
+	}{
+		{name: "unchanged"},
+		{name: "table added", edit: "CREATE TABLE extra (id INTEGER);", drift: true},
+		{name: "table dropped", edit: "DROP TABLE t1;", drift: true},
+		{name: "index added", edit: "CREATE INDEX t0_id ON t0 (id);", drift: true},
+		{name: "rows added", edit: "INSERT INTO t0 (id) VALUES (1);"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+			if err := s.Migrate(ctx, db); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			// The first call records the hash of the migrated schema.
+			if err := s.CheckDrift(ctx, db); err != nil {
+				t.Fatal("cannot record schema hash:", err)
+			}
+
+			if test.edit != "" {
+				if _, err := db.Exec(test.edit); err != nil {
+					t.Fatal("cannot edit database:", err)
+				}
+			}
+
+			err := s.CheckDrift(ctx, db)
+			if test.drift != errors.Is(err, lazymigrate.ErrSchemaDrift) || (!test.drift && err != nil) {
+				t.Fatalf("CheckDrift() = %v, want drift = %v", err, test.drift)
+			}
+		})
+	}
+}
+
+// countingConnector opens SQLite connections that count how often
+// database/sql prepares a statement on them.
+type countingConnector struct {
+	dsn      string
+	prepares *atomic.Int32
+}
+
+func (c countingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn.(*sqlite3.SQLiteConn), c.prepares}, nil
+}
+
+func (c countingConnector) Driver() driver.Driver { return &sqlite3.SQLiteDriver{} }
+
+// countingConn is an SQLite connection that counts prepared statements. The
+// other methods of the connection, such as ExecContext, are used as-is.
+type countingConn struct {
+	*sqlite3.SQLiteConn
+	prepares *atomic.Int32
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.prepares.Add(1)
+	return c.SQLiteConn.Prepare(query)
+}
+
+func (c countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.prepares.Add(1)
+	return c.SQLiteConn.PrepareContext(ctx, query)
+}
+
+func TestMigrateDoesNotPrepare(t *testing.T) {
+	// The second version uses a column added by the first one within the
+	// same transaction, which a statement prepared before the ALTER TABLE
+	// would not see.
+	schema, err := lazymigrate.Join(
+		"CREATE TABLE users (id INTEGER PRIMARY KEY);\n"+
+			"ALTER TABLE users ADD COLUMN name TEXT;",
+		"INSERT INTO users (name) VALUES ('alice');\n"+
+			"ALTER TABLE users ADD COLUMN email TEXT;\n"+
+			"UPDATE users SET email = name || '@example.com';",
+	)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+
+	tests := []struct {
+		name   string
+		schema *lazymigrate.Schema
+	}{
+		{"PragmaStore", lazymigrate.NewSchema(schema)},
+		{"TableStore", lazymigrate.NewSchemaWithTable(schema, "version")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var prepares atomic.Int32
+			db := sql.OpenDB(countingConnector{
+				dsn:      "file:" + filepath.Join(t.TempDir(), "test.db"),
+				prepares: &prepares,
+			})
+			t.Cleanup(func() { db.Close() })
+
+			if err := test.schema.Migrate(context.Background(), db); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+			if n := prepares.Load(); n != 0 {
+				t.Errorf("migrating prepared %d statements, want 0", n)
+			}
+
+			var email string
+			if err := db.QueryRow("SELECT email FROM users").Scan(&email); err != nil {
+				t.Fatal("cannot query users:", err)
+			}
+			if email != "alice@example.com" {
+				t.Errorf("email = %q, want %q", email, "alice@example.com")
+			}
+		})
+	}
+}
+
+func TestMigrateSavepoint(t *testing.T) {
+	failing, err := lazymigrate.Join(
+		"CREATE TABLE a (id INTEGER);",
+		"INSERT INTO missing VALUES (1);",
+	)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+
+	tests := []struct {
+		name    string
+		schema  string
+		fails   bool
+		version int
+		tables  []string
+	}{
+		{
+			name:    "success",
+			schema:  loggingSchema(2),
+			version: 2,
+			tables:  []string{"applied", "outer_work", "t0", "t1"},
+		},
+		{
+			name:    "failure",
+			schema:  failing,
+			fails:   true,
+			version: 0,
+			tables:  []string{"outer_work"},
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+			if _, err := db.Exec("CREATE TABLE outer_work (step TEXT NOT NULL)"); err != nil {
+				t.Fatal("cannot create table:", err)
+			}
+
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal("cannot begin transaction:", err)
+			}
+			defer tx.Rollback()
+
+			// The caller's work before migrating, within a savepoint of its
+			// own.
+			if _, err := tx.Exec("INSERT INTO outer_work VALUES ('before'); SAVEPOINT caller"); err != nil {
+				t.Fatal("cannot do work before migrating:", err)
+			}
+
+			s := lazymigrate.NewSchema(test.schema)
+			if err := s.MigrateSavepoint(ctx, tx, "lazymigrate"); (err != nil) != test.fails {
+				t.Fatalf("MigrateSavepoint() = %v, want failure = %v", err, test.fails)
+			}
+
+			// The savepoint is released either way, but the caller's one and
+			// the transaction are still there.
+			if _, err := tx.Exec(`ROLLBACK TO "lazymigrate"`); err == nil {
+				t.Error("savepoint lazymigrate was not released")
+			}
+			if _, err := tx.Exec("INSERT INTO outer_work VALUES ('after'); RELEASE caller"); err != nil {
+				t.Fatal("cannot do work after migrating:", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal("cannot commit:", err)
+			}
+
+			var steps []string
+			rows, err := db.Query("SELECT step FROM outer_work ORDER BY rowid")
+			if err != nil {
+				t.Fatal("cannot query outer work:", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var step string
+				if err := rows.Scan(&step); err != nil {
+					t.Fatal("cannot scan outer work:", err)
+				}
+				steps = append(steps, step)
+			}
+			if want := []string{"before", "after"}; !slices.Equal(steps, want) {
+				t.Errorf("outer work = %q, want %q", steps, want)
+			}
+
+			var tables []string
+			trows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+			if err != nil {
+				t.Fatal("cannot query tables:", err)
+			}
+			defer trows.Close()
+			for trows.Next() {
+				var name string
+				if err := trows.Scan(&name); err != nil {
+					t.Fatal("cannot scan table:", err)
+				}
+				tables = append(tables, name)
+			}
+			if !slices.Equal(tables, test.tables) {
+				t.Errorf("tables = %q, want %q", tables, test.tables)
+			}
+
+			var v int
+			if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+				t.Fatal("cannot read user_version:", err)
+			}
+			if v != test.version {
+				t.Errorf("user_version = %d, want %d", v, test.version)
+			}
+		})
+	}
+}
+
+func TestValidateSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		// index is the index of the failing version, or -1 if ValidateSQL
+		// should succeed.
+		index int
+		err   error
+	}{
+		{
+			name: "valid",
+			versions: []string{
+				"CREATE TABLE a (id INTEGER);",
+				"ALTER TABLE a ADD COLUMN name TEXT;\nCREATE INDEX a_name ON a (name);",
+			},
+			index: -1,
+		},
+		{
+			name: "invalid down section",
+			versions: []string{
+				"CREATE TABLE a (id INTEGER);\n-- DOWN --\nDROP TABLE nothing at all;",
+			},
+			index: -1,
+		},
+		{
+			name: "syntax error",
+			versions: []string{
+				"CREATE TABLE a (id INTEGER);",
+				"CREATE TABLE b (id INTEGER;",
+			},
+			index: 1,
+		},
+		{
+			name: "missing table",
+			versions: []string{
+				"CREATE TABLE a (id INTEGER);",
+				"ALTER TABLE b ADD COLUMN name TEXT;",
+				"CREATE TABLE c (id INTEGER);",
+			},
+			index: 1,
+		},
+		{
+			name: "empty version",
+			versions: []string{
+				"CREATE TABLE a (id INTEGER);",
+				"-- DOWN --\nDROP TABLE a;",
+			},
+			index: 1,
+			err:   lazymigrate.ErrEmptyVersion,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := lazymigrate.Join(test.versions...)
+			if err != nil {
+				t.Fatal("cannot join versions:", err)
+			}
+
+			err = lazymigrate.NewSchema(schema).ValidateSQL(context.Background())
+			switch {
+			case test.index == -1:
+				if err != nil {
+					t.Fatal("ValidateSQL() on a valid schema:", err)
+				}
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("ValidateSQL() = %v, want an error wrapping %v", err, test.err)
+				}
+			default:
+				var merr *lazymigrate.MigrationError
+				if !errors.As(err, &merr) {
+					t.Fatalf("ValidateSQL() = %v, want a *MigrationError", err)
+				}
+				if merr.Index != test.index {
+					t.Errorf("ValidateSQL() failed at version %d, want %d", merr.Index, test.index)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSQLDriver(t *testing.T) {
+	old := lazymigrate.ValidateDriverName
+	t.Cleanup(func() { lazymigrate.ValidateDriverName = old })
+	lazymigrate.ValidateDriverName = "lazymigrate-missing"
+
+	if err := lazymigrate.NewSchema("CREATE TABLE a (id INTEGER);").ValidateSQL(context.Background()); err == nil {
+		t.Error("ValidateSQL() with a missing driver succeeded")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		setup   func(*lazymigrate.Schema)
+		invalid bool
+	}{
+		{
+			name:   "valid",
+			schema: loggingSchema(3),
+		},
+		{
+			name:    "invalid",
+			schema:  loggingSchema(2) + "\n" + lazymigrate.Delimiter + "\nCREATE TABLE t0 (id INTEGER);",
+			invalid: true,
+		},
+		{
+			name:   "version not recorded",
+			schema: loggingSchema(3),
+			setup: func(s *lazymigrate.Schema) {
+				s.SetVersion = func(ctx context.Context, q lazymigrate.DBTX, v int) error { return nil }
+			},
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Each connection has its own in-memory database, so this only
+			// works if migrating and checking share a connection.
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal("cannot open database:", err)
+			}
+			t.Cleanup(func() { db.Close() })
+
+			s := lazymigrate.NewSchema(test.schema)
+			if test.setup != nil {
+				test.setup(s)
+			}
+
+			err = s.Verify(context.Background(), db)
+			if invalid := err != nil; invalid != test.invalid {
+				t.Errorf("Verify() = %v, want invalid = %v", err, test.invalid)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+	s := lazymigrate.NewSchema(loggingSchema(3))
+	if err := s.MigrateTo(ctx, db, 1); err != nil {
+		t.Fatal("cannot migrate to version 1:", err)
+	}
+
+	if err := s.DryRun(ctx, db); err != nil {
+		t.Fatal("cannot dry run:", err)
+	}
+
+	// Nothing after version 1 may be left behind.
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('t1', 't2')").Scan(&tables); err != nil {
+		t.Fatal("cannot count tables:", err)
+	}
+	if tables != 0 {
+		t.Errorf("dry run left %d tables behind", tables)
+	}
+	checkAppliedOnce(t, db, 1)
+
+	// A failing version fails the dry run.
+	broken := lazymigrate.NewSchema(loggingSchema(1) + "\n" + lazymigrate.Delimiter + "\nCREATE TABLE t0 (id INTEGER);")
+	if err := broken.DryRun(ctx, db); err == nil {
+		t.Error("DryRun() of a failing version succeeded")
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+	s := lazymigrate.NewSchema("CREATE TABLE IF NOT EXISTS a (id INTEGER);")
+	if err := s.Migrate(ctx, db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	if err := s.Reset(ctx, db); err != nil {
+		t.Fatal("cannot reset:", err)
+	}
+
+	// The table is left as-is.
+	if _, err := db.Exec("INSERT INTO a VALUES (1)"); err != nil {
+		t.Error("cannot insert into the table after resetting:", err)
+	}
+
+	v, err := s.CurrentVersion(ctx, db)
+	if err != nil {
+		t.Fatal("cannot get version:", err)
+	}
+	if v != 0 {
+		t.Errorf("version after resetting = %d, want 0", v)
+	}
+
+	if err := s.Migrate(ctx, db); err != nil {
+		t.Fatal("cannot migrate again:", err)
+	}
+	if v, _ := s.CurrentVersion(ctx, db); v != 1 {
+		t.Errorf("version after migrating again = %d, want 1", v)
+	}
+}
+
+func TestCheckForeignKeys(t *testing.T) {
+	const parent = "CREATE TABLE parent (id INTEGER PRIMARY KEY);\n" +
+		"CREATE TABLE child (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parent (id));\n" +
+		"INSERT INTO parent (id) VALUES (1);"
+
+	tests := []struct {
+		name       string
+		insert     string
+		violations []lazymigrate.ForeignKeyViolation
+	}{
+		{
+			name:   "valid",
+			insert: "INSERT INTO child (id, parent_id) VALUES (1, 1);",
+		},
+		{
+			// Foreign keys are not enforced by default, so the broken row
+			// can be inserted.
+			name:   "missing parent",
+			insert: "INSERT INTO child (id, parent_id) VALUES (1, 1), (2, 2);",
+			violations: []lazymigrate.ForeignKeyViolation{
+				{Table: "child", RowID: sql.NullInt64{Int64: 2, Valid: true}, Parent: "parent", FKID: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+			schema, err := lazymigrate.Join(parent, test.insert)
+			if err != nil {
+				t.Fatal("cannot join versions:", err)
+			}
+
+			s := lazymigrate.NewSchema(schema)
+			s.CheckForeignKeys = true
+
+			err = s.Migrate(context.Background(), db)
+
+			var fkerr *lazymigrate.ForeignKeyError
+			if errors.As(err, &fkerr) != (test.violations != nil) {
+				t.Fatalf("Migrate() = %v, want *ForeignKeyError = %v", err, test.violations != nil)
+			}
+			if fkerr == nil {
+				if err != nil {
+					t.Fatal("cannot migrate:", err)
+				}
+				return
+			}
+
+			if !slices.Equal(fkerr.Violations, test.violations) {
+				t.Errorf("Violations = %+v, want %+v", fkerr.Violations, test.violations)
+			}
+
+			// The migration is rolled back.
+			var v int
+			if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+				t.Fatal("cannot read user_version:", err)
+			}
+			if v != 0 {
+				t.Errorf("user_version = %d, want 0", v)
+			}
+		})
+	}
+}
+
+func TestMigrateTx(t *testing.T) {
+	ctx := context.Background()
+	db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+	s := lazymigrate.NewSchema(loggingSchema(2))
+
+	// Rolling back the caller's transaction undoes the migrations too.
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("cannot begin transaction:", err)
+	}
+	if err := s.MigrateTx(ctx, tx); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal("cannot roll back:", err)
+	}
+
+	if v, err := s.CurrentVersion(ctx, db); err != nil || v != 0 {
+		t.Fatalf("CurrentVersion() after rolling back = (%d, %v), want 0", v, err)
+	}
+
+	// Committing it commits the migrations along with the caller's work.
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal("cannot begin transaction:", err)
+	}
+	if err := s.MigrateTx(ctx, tx); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t0 (id) VALUES (1)"); err != nil {
+		t.Fatal("cannot insert seed data:", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal("cannot commit:", err)
+	}
+
+	checkAppliedOnce(t, db, 2)
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t0").Scan(&n); err != nil {
+		t.Fatal("cannot count seed data:", err)
+	}
+	if n != 1 {
+		t.Errorf("t0 has %d rows, want 1", n)
+	}
+}
+
+func TestTableStoreAppliedAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		appliedAt string
+		// want is the pattern that every applied_at must be LIKE.
+		want string
+	}{
+		{name: "default", want: "____-__-__ __:__:__"},
+		{name: "custom", appliedAt: "'2024-01-15'", want: "2024-01-15"},
+		{name: "unix time", appliedAt: "unixepoch()", want: "1%"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+			store := lazymigrate.TableStore{Table: "version", AppliedAt: test.appliedAt}
+			s := lazymigrate.NewSchemaWithStore(loggingSchema(2), lazymigrate.Delimiter, store)
+			if err := s.Migrate(context.Background(), db); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			var total, matching int
+			if err := db.QueryRow(
+				"SELECT COUNT(*), COUNT(*) FILTER (WHERE applied_at LIKE ?) FROM version", test.want,
+			).Scan(&total, &matching); err != nil {
+				t.Fatal("cannot read version table:", err)
+			}
+			if total != 2 || matching != 2 {
+				t.Errorf("%d of %d versions have applied_at like %q, want all of 2", matching, total, test.want)
+			}
+		})
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openFileDB(t, filepath.Join(t.TempDir(), "test.db"))
+
+	schema, err := lazymigrate.Join(
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE b (id INTEGER);",
+		"CREATE TABLE c (id INTEGER);",
+	)
+	if err != nil {
+		t.Fatal("cannot join versions:", err)
+	}
+	s := lazymigrate.NewSchema(schema)
+
+	if err := s.Baseline(ctx, db, 2); err != nil {
+		t.Fatal("cannot baseline:", err)
+	}
+	if err := s.Migrate(ctx, db); err != nil {
+		t.Fatal("cannot migrate after baselining:", err)
+	}
+
+	// Only the version after the baseline is applied.
+	var tables []string
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		t.Fatal("cannot list tables:", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal("cannot scan table:", err)
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal("cannot list tables:", err)
+	}
+	if want := []string{"c"}; !slices.Equal(tables, want) {
+		t.Errorf("tables = %q, want %q", tables, want)
+	}
+
+	if err := s.Baseline(ctx, db, 1); err == nil {
+		t.Error("Baseline() to a lower version succeeded")
+	}
+	for _, version := range []int{-1, 4} {
+		if err := s.ForceBaseline(ctx, db, version); err == nil {
+			t.Errorf("ForceBaseline(%d) succeeded", version)
+		}
+	}
+
+	if err := s.ForceBaseline(ctx, db, 1); err != nil {
+		t.Fatal("cannot force baseline to a lower version:", err)
+	}
+	if v, err := s.CurrentVersion(ctx, db); err != nil || v != 1 {
+		t.Errorf("CurrentVersion() after forcing the baseline = (%d, %v), want 1", v, err)
+	}
+}
+
+func TestMigrateConn(t *testing.T) {
+	ctx := context.Background()
+
+	// Each connection has its own in-memory database, so the migrated tables
+	// are only visible on the connection that was migrated.
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("cannot open database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal("cannot get connection:", err)
+	}
+	defer conn.Close()
+
+	if err := lazymigrate.NewSchema(loggingSchema(2)).MigrateConn(ctx, conn); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	var n int
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM applied").Scan(&n); err != nil {
+		t.Fatal("cannot query the migrated connection:", err)
+	}
+	if n != 2 {
+		t.Errorf("%d versions were applied, want 2", n)
+	}
+}
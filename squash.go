@@ -0,0 +1,56 @@
+package lazymigrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Squash returns a copy of the Schema where the first upTo versions are
+// replaced by a single version that contains all of their statements, in
+// order. The versions after them, and the magic comments between those, are
+// kept as-is. The copy has the same options and version store as s. It is
+// meant for long-lived schemas that have accumulated many versions.
+//
+// Squashing renumbers the versions: a database at version v, where v >= upTo,
+// corresponds to version v-upTo+1 of the squashed schema. The caller is
+// responsible for moving existing databases to the new numbering, such as with
+// [Schema.ForceBaseline], before migrating them with the squashed schema.
+// Databases that are not fully migrated to upTo cannot be migrated with it.
+//
+// The down sections of the squashed versions are dropped, so the squashed
+// version cannot be undone with [Schema.MigrateDownTo].
+func (s *Schema) Squash(upTo int) (*Schema, error) {
+	spans := s.VersionSpans()
+	if upTo < 1 || upTo > len(spans) {
+		return nil, fmt.Errorf("cannot squash %d versions: schema has %d versions", upTo, len(spans))
+	}
+
+	squashed := *s
+	squashed.lazy = nil
+	squashed.schema = s.joinUps(spans[:upTo]) + s.schema[spans[upTo-1].End:]
+	return &squashed, nil
+}
+
+// SchemaAt returns the SQL of the first version versions of the schema joined
+// together, without magic comments or down sections. It is the SQL that a
+// fresh database would have to run to reach that version, which is useful for
+// documentation, snapshot tests and building reference databases. The version
+// must be between 0 and [Schema.VersionCount], inclusive.
+func (s *Schema) SchemaAt(version int) (string, error) {
+	spans := s.VersionSpans()
+	if version < 0 || version > len(spans) {
+		return "", fmt.Errorf("cannot get schema at version %d: schema has %d versions", version, len(spans))
+	}
+	return s.joinUps(spans[:version]), nil
+}
+
+// joinUps joins the up sections of the versions at the given spans with blank
+// lines.
+func (s *Schema) joinUps(spans []VersionSpan) string {
+	ups := make([]string, len(spans))
+	for i, span := range spans {
+		up, _, _ := splitDown(s.schema[span.Start:span.End])
+		ups[i] = strings.TrimSpace(up)
+	}
+	return strings.Join(ups, "\n\n")
+}
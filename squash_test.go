@@ -0,0 +1,115 @@
+package lazymigrate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSquash(t *testing.T) {
+	const schema = "CREATE TABLE a (id INTEGER);\n" +
+		"-- DOWN --\n" +
+		"DROP TABLE a;\n" +
+		"-- migrate\n" +
+		"CREATE TABLE b (id INTEGER);\n" +
+		"-- migrate\n" +
+		"CREATE TABLE c (id INTEGER);\n" +
+		"-- DOWN --\n" +
+		"DROP TABLE c;"
+
+	tests := []struct {
+		name string
+		upTo int
+		want []string
+	}{
+		{
+			name: "first",
+			upTo: 1,
+			want: []string{
+				"CREATE TABLE a (id INTEGER);",
+				"CREATE TABLE b (id INTEGER);",
+				"CREATE TABLE c (id INTEGER);\n-- DOWN --\nDROP TABLE c;",
+			},
+		},
+		{
+			name: "some",
+			upTo: 2,
+			want: []string{
+				"CREATE TABLE a (id INTEGER);\n\nCREATE TABLE b (id INTEGER);",
+				"CREATE TABLE c (id INTEGER);\n-- DOWN --\nDROP TABLE c;",
+			},
+		},
+		{
+			name: "all",
+			upTo: 3,
+			want: []string{
+				"CREATE TABLE a (id INTEGER);\n\nCREATE TABLE b (id INTEGER);\n\nCREATE TABLE c (id INTEGER);",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewSchemaWithMagic(schema, testMagic)
+			s.Strict = true
+
+			squashed, err := s.Squash(test.upTo)
+			if err != nil {
+				t.Fatal("cannot squash:", err)
+			}
+
+			if got := squashed.Versions(); !slices.Equal(got, test.want) {
+				t.Errorf("Versions() = %q, want %q", got, test.want)
+			}
+			if !squashed.Strict {
+				t.Error("squashed schema lost its options")
+			}
+			if err := squashed.Validate(); err != nil {
+				t.Error("squashed schema is invalid:", err)
+			}
+
+			// The original schema is left as-is.
+			if n := s.VersionCount(); n != 3 {
+				t.Errorf("original schema has %d versions after squashing, want 3", n)
+			}
+		})
+	}
+}
+
+func TestSquashInvalid(t *testing.T) {
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+
+	for _, upTo := range []int{-1, 0, 3} {
+		if _, err := s.Squash(upTo); err == nil {
+			t.Errorf("Squash(%d) succeeded, want an error", upTo)
+		}
+	}
+}
+
+func TestSchemaAt(t *testing.T) {
+	s := NewSchemaWithMagic(""+
+		"CREATE TABLE a (id INTEGER);\n"+
+		"-- DOWN --\n"+
+		"DROP TABLE a;\n"+
+		"-- migrate\n"+
+		"CREATE TABLE b (id INTEGER);",
+		testMagic)
+
+	want := []string{
+		"",
+		"CREATE TABLE a (id INTEGER);",
+		"CREATE TABLE a (id INTEGER);\n\nCREATE TABLE b (id INTEGER);",
+	}
+	for version, want := range want {
+		got, err := s.SchemaAt(version)
+		if err != nil {
+			t.Fatalf("cannot get schema at version %d: %v", version, err)
+		}
+		if got != want {
+			t.Errorf("SchemaAt(%d) = %q, want %q", version, got, want)
+		}
+	}
+
+	if _, err := s.SchemaAt(3); err == nil {
+		t.Error("SchemaAt(3) succeeded, want an error")
+	}
+}
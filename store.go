@@ -0,0 +1,342 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// ErrReadVersion is wrapped by the errors returned when the version stores in
+// this package fail to read the version of the database. A fresh database,
+// where the version has never been set, is not an error and reads as version
+// 0.
+var ErrReadVersion = errors.New("cannot read database version")
+
+// DBTX is the subset of methods shared by *sql.DB, *sql.Conn and *sql.Tx. The
+// DBTX interface generated by sqlc also satisfies it.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// VersionStore reads and writes the version of a database. The version is the
+// number of versions of the schema that have been applied.
+//
+// While migrating, q is the migration's transaction, so a store may create any
+// tables it needs in Set without leaving them behind if the migration fails.
+// Get may also be called outside of a transaction and must not modify the
+// database.
+type VersionStore interface {
+	// Get returns the current version of the database. It returns 0 if the
+	// database has never been migrated.
+	Get(ctx context.Context, q DBTX) (int, error)
+	// Set records that the database is now at version v. It is called after
+	// each version is applied, and by [Schema.Baseline], in which case v may
+	// be lower than the current version.
+	Set(ctx context.Context, q DBTX, v int) error
+}
+
+// VersionLocker is an optional interface that a [VersionStore] may implement
+// to serialize concurrent migrations of the same database. Lock is called with
+// the migration's transaction before the version is read, and the lock must be
+// released when that transaction ends.
+type VersionLocker interface {
+	Lock(ctx context.Context, tx DBTX) error
+}
+
+// ChecksumStore is an optional interface that a [VersionStore] may implement
+// to record a checksum of each applied version. It is required to verify
+// checksums with [Schema.VerifyChecksums].
+type ChecksumStore interface {
+	VersionStore
+	// SetChecksum records the checksum of the version at the given index. It
+	// is called right after Set.
+	SetChecksum(ctx context.Context, q DBTX, index int, checksum string) error
+	// Checksums returns the recorded checksums keyed by version index.
+	// Versions without a recorded checksum are not in the map.
+	Checksums(ctx context.Context, q DBTX) (map[int]string, error)
+}
+
+// PragmaStore is a [VersionStore] that stores the version in an SQLite
+// pragma. It is the default store, using user_version.
+type PragmaStore struct {
+	// Name is the name of the pragma, such as "user_version". It is not
+	// escaped, so it must be a trusted identifier.
+	Name string
+}
+
+var _ VersionStore = PragmaStore{}
+
+// Get implements [VersionStore].
+func (p PragmaStore) Get(ctx context.Context, q DBTX) (int, error) {
+	return readPragma(ctx, q, p.Name)
+}
+
+// Set implements [VersionStore].
+func (p PragmaStore) Set(ctx context.Context, q DBTX, v int) error {
+	return setPragma(ctx, q, p.Name, v)
+}
+
+// readPragma reads an integer pragma. Some drivers return no rows or NULL for
+// pragmas that were never set, which is treated as 0, like a fresh database.
+func readPragma(ctx context.Context, q DBTX, name string) (int, error) {
+	var v sql.NullInt64
+	err := q.QueryRowContext(ctx, "PRAGMA "+name).Scan(&v)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: PRAGMA %s: %w", ErrReadVersion, name, err)
+	}
+	return int(v.Int64), nil
+}
+
+// setPragma sets an integer pragma. SQLite does not allow binding parameters
+// in pragma statements, so the value is formatted into the statement.
+func setPragma(ctx context.Context, q DBTX, name string, v int) error {
+	// Integer pragmas like user_version and application_id are 32-bit, and
+	// SQLite silently truncates larger values.
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return fmt.Errorf("cannot set PRAGMA %s: %d does not fit in 32 bits", name, v)
+	}
+
+	if _, err := q.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %d", name, v)); err != nil {
+		return fmt.Errorf("cannot set PRAGMA %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// TableStore is a [VersionStore] that stores the version in an SQLite table.
+// A row with the version and the time it was applied is inserted for every
+// version applied, and the current version is the version in the most recently
+// inserted row. The table is created if it does not exist.
+//
+// TableStore also implements [ChecksumStore] by keeping the checksum of each
+// version in the same row.
+type TableStore struct {
+	// Table is the name of the table.
+	Table string
+	// AppliedAt is the SQL expression that is stored as the time a version
+	// was applied. It is evaluated by the database, so that databases
+	// migrated from several machines share the same clock. If empty,
+	// CURRENT_TIMESTAMP is used.
+	AppliedAt string
+}
+
+var _ ChecksumStore = TableStore{}
+
+// Get implements [VersionStore].
+func (t TableStore) Get(ctx context.Context, q DBTX) (int, error) {
+	exists, err := t.exists(ctx, q)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return readLatestVersion(ctx, q, t.Table, "rowid")
+}
+
+func (t TableStore) exists(ctx context.Context, q DBTX) (bool, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", t.Table,
+	).Scan(&exists); err != nil {
+		return false, fmt.Errorf("%w: cannot check for version table %s: %w", ErrReadVersion, t.Table, err)
+	}
+	return exists, nil
+}
+
+func (t TableStore) hasChecksums(ctx context.Context, q DBTX) (bool, error) {
+	var has bool
+	if err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = 'checksum'", t.Table,
+	).Scan(&has); err != nil {
+		return false, fmt.Errorf("cannot check for checksum column in %s: %w", t.Table, err)
+	}
+	return has, nil
+}
+
+// Set implements [VersionStore].
+func (t TableStore) Set(ctx context.Context, q DBTX, v int) error {
+	if _, err := q.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+quoteIdent(t.Table)+" ("+
+			"version INTEGER NOT NULL, "+
+			"applied_at TEXT NOT NULL, "+
+			"checksum TEXT)",
+	); err != nil {
+		return fmt.Errorf("cannot create version table %s: %w", t.Table, err)
+	}
+
+	if _, err := q.ExecContext(ctx,
+		"INSERT INTO "+quoteIdent(t.Table)+" (version, applied_at) VALUES (?, "+appliedAt(t.AppliedAt, "CURRENT_TIMESTAMP")+")", v,
+	); err != nil {
+		return fmt.Errorf("cannot insert into version table %s: %w", t.Table, err)
+	}
+
+	return nil
+}
+
+// SetChecksum implements [ChecksumStore]. Tables created before checksums were
+// supported get a checksum column added.
+func (t TableStore) SetChecksum(ctx context.Context, q DBTX, index int, checksum string) error {
+	has, err := t.hasChecksums(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		if _, err := q.ExecContext(ctx,
+			"ALTER TABLE "+quoteIdent(t.Table)+" ADD COLUMN checksum TEXT",
+		); err != nil {
+			return fmt.Errorf("cannot add checksum column to %s: %w", t.Table, err)
+		}
+	}
+
+	if _, err := q.ExecContext(ctx,
+		"UPDATE "+quoteIdent(t.Table)+" SET checksum = ? "+
+			"WHERE rowid = (SELECT MAX(rowid) FROM "+quoteIdent(t.Table)+" WHERE version = ?)",
+		checksum, index+1,
+	); err != nil {
+		return fmt.Errorf("cannot set checksum in %s: %w", t.Table, err)
+	}
+
+	return nil
+}
+
+// Checksums implements [ChecksumStore].
+func (t TableStore) Checksums(ctx context.Context, q DBTX) (map[int]string, error) {
+	sums := make(map[int]string)
+
+	exists, err := t.exists(ctx, q)
+	if err != nil || !exists {
+		return sums, err
+	}
+
+	has, err := t.hasChecksums(ctx, q)
+	if err != nil || !has {
+		return sums, err
+	}
+
+	rows, err := q.QueryContext(ctx,
+		"SELECT version, checksum FROM "+quoteIdent(t.Table)+" WHERE checksum IS NOT NULL ORDER BY rowid",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get checksums from %s: %w", t.Table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			return nil, fmt.Errorf("cannot scan checksum from %s: %w", t.Table, err)
+		}
+		sums[v-1] = sum
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("cannot get checksums from %s: %w", t.Table, err)
+	}
+
+	return sums, nil
+}
+
+// PostgresStore is a [VersionStore] for PostgreSQL databases. It works like
+// [TableStore], but uses PostgreSQL syntax. It also implements [VersionLocker]
+// using a transaction-level advisory lock, so concurrent migrations wait for
+// each other instead of applying the same versions twice.
+type PostgresStore struct {
+	// Table is the name of the table. If empty, "schema_version" is used.
+	Table string
+	// AppliedAt is the SQL expression that is stored as the time a version
+	// was applied, like [TableStore.AppliedAt]. If empty, now() is used.
+	AppliedAt string
+}
+
+var (
+	_ VersionStore  = PostgresStore{}
+	_ VersionLocker = PostgresStore{}
+)
+
+func (p PostgresStore) table() string {
+	if p.Table == "" {
+		return "schema_version"
+	}
+	return p.Table
+}
+
+// Get implements [VersionStore].
+func (p PostgresStore) Get(ctx context.Context, q DBTX) (int, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx,
+		"SELECT to_regclass($1) IS NOT NULL", quoteIdent(p.table()),
+	).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("%w: cannot check for version table %s: %w", ErrReadVersion, p.table(), err)
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	return readLatestVersion(ctx, q, p.table(), "id")
+}
+
+// Lock implements [VersionLocker]. The advisory lock key is derived from the
+// table name.
+func (p PostgresStore) Lock(ctx context.Context, tx DBTX) error {
+	h := fnv.New64a()
+	h.Write([]byte("lazymigrate:" + p.table()))
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", int64(h.Sum64())); err != nil {
+		return fmt.Errorf("cannot take advisory lock: %w", err)
+	}
+	return nil
+}
+
+// Set implements [VersionStore].
+func (p PostgresStore) Set(ctx context.Context, q DBTX, v int) error {
+	if _, err := q.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS "+quoteIdent(p.table())+" ("+
+			"id BIGSERIAL PRIMARY KEY, "+
+			"version INTEGER NOT NULL, "+
+			"applied_at TIMESTAMPTZ NOT NULL DEFAULT now())",
+	); err != nil {
+		return fmt.Errorf("cannot create version table %s: %w", p.table(), err)
+	}
+
+	if _, err := q.ExecContext(ctx,
+		"INSERT INTO "+quoteIdent(p.table())+" (version, applied_at) VALUES ($1, "+appliedAt(p.AppliedAt, "now()")+")", v,
+	); err != nil {
+		return fmt.Errorf("cannot insert into version table %s: %w", p.table(), err)
+	}
+
+	return nil
+}
+
+// appliedAt returns expr, or def if it is empty.
+func appliedAt(expr, def string) string {
+	if expr == "" {
+		return def
+	}
+	return expr
+}
+
+// readLatestVersion reads the version from the most recently inserted row,
+// ordered by the given column. This allows the version to be lowered, such as
+// by [Schema.ForceBaseline], by inserting a row with a lower version.
+func readLatestVersion(ctx context.Context, q DBTX, table, orderBy string) (int, error) {
+	var v int
+	err := q.QueryRowContext(ctx,
+		"SELECT version FROM "+quoteIdent(table)+" ORDER BY "+orderBy+" DESC LIMIT 1",
+	).Scan(&v)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: table %s: %w", ErrReadVersion, table, err)
+	}
+	return v, nil
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,213 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMigrateSetsPragma(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	schema := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+	if err := schema.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	want := []string{
+		"BEGIN IMMEDIATE",
+		"CREATE TABLE a (id INTEGER);",
+		"PRAGMA user_version = 1",
+		"CREATE TABLE b (id INTEGER);",
+		"PRAGMA user_version = 2",
+		"COMMIT",
+	}
+	if got := f.statements(); !slices.Equal(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+}
+
+func TestNewSchemaWithPragma(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	f.results["PRAGMA application_id"] = [][]driver.Value{{int64(1)}}
+
+	schema := NewSchemaWithPragma("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic, "application_id")
+	if err := schema.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	// user_version is left alone.
+	want := []string{
+		"BEGIN IMMEDIATE",
+		"CREATE TABLE b (id INTEGER);",
+		"PRAGMA application_id = 2",
+		"COMMIT",
+	}
+	if got := f.statements(); !slices.Equal(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+}
+
+func TestSetPragma(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+	ctx := context.Background()
+
+	if err := setPragma(ctx, db, "application_id", 42); err != nil {
+		t.Fatal("cannot set pragma:", err)
+	}
+	if err := setPragma(ctx, db, "user_version", 1<<31); err == nil {
+		t.Error("setPragma() with a value over 32 bits = nil, want an error")
+	}
+
+	want := []string{"PRAGMA application_id = 42"}
+	if got := f.statements(); !slices.Equal(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+}
+
+func TestReadPragma(t *testing.T) {
+	errQuery := errors.New("no such pragma")
+
+	tests := []struct {
+		name string
+		rows [][]driver.Value
+		err  error
+		want int
+	}{
+		{name: "value", rows: [][]driver.Value{{int64(3)}}, want: 3},
+		{name: "zero", rows: [][]driver.Value{{int64(0)}}, want: 0},
+		{name: "no rows", rows: [][]driver.Value{}, want: 0},
+		{name: "null", rows: [][]driver.Value{{nil}}, want: 0},
+		{name: "error", err: errQuery},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+			f.results["PRAGMA user_version"] = test.rows
+			if test.err != nil {
+				f.errs["PRAGMA user_version"] = test.err
+			}
+
+			v, err := readPragma(context.Background(), db, "user_version")
+			if test.err != nil {
+				if !errors.Is(err, ErrReadVersion) || !errors.Is(err, test.err) {
+					t.Errorf("readPragma() = %v, want an error wrapping ErrReadVersion and %v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			if v != test.want {
+				t.Errorf("readPragma() = %d, want %d", v, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrateGetSetVersion(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	// Keep the version of a single tenant in a map instead of user_version.
+	versions := map[string]int{"tenant": 1}
+
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+	s.GetVersion = func(ctx context.Context, q DBTX) (int, error) {
+		return versions["tenant"], nil
+	}
+	s.SetVersion = func(ctx context.Context, q DBTX, v int) error {
+		versions["tenant"] = v
+		return nil
+	}
+
+	if err := s.Migrate(context.Background(), db); err != nil {
+		t.Fatal("cannot migrate:", err)
+	}
+
+	if v := versions["tenant"]; v != 2 {
+		t.Errorf("tenant is at version %d, want 2", v)
+	}
+
+	want := []string{"BEGIN IMMEDIATE", "CREATE TABLE b (id INTEGER);", "COMMIT"}
+	if got := f.statements(); !slices.Equal(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+
+	v, err := s.CurrentVersion(context.Background(), db)
+	if err != nil {
+		t.Fatal("cannot get version:", err)
+	}
+	if v != 2 {
+		t.Errorf("CurrentVersion() = %d, want 2", v)
+	}
+}
+
+func TestMigrateSetVersionError(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	failure := errors.New("tenant is gone")
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);", testMagic)
+	s.SetVersion = func(ctx context.Context, q DBTX, v int) error { return failure }
+
+	if err := s.Migrate(context.Background(), db); !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+
+	want := []string{"BEGIN IMMEDIATE", "CREATE TABLE a (id INTEGER);", "ROLLBACK"}
+	if got := f.statements(); !slices.Equal(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	const latest = `SELECT version FROM "versions" ORDER BY id DESC LIMIT 1`
+
+	tests := []struct {
+		name   string
+		exists bool
+		v      int64
+		want   []string
+	}{
+		{
+			name: "fresh",
+			want: []string{
+				"BEGIN",
+				"SELECT pg_advisory_xact_lock($1)",
+				"CREATE TABLE a (id INTEGER);",
+				`CREATE TABLE IF NOT EXISTS "versions" (id BIGSERIAL PRIMARY KEY, version INTEGER NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+				`INSERT INTO "versions" (version, applied_at) VALUES ($1, now())`,
+				"CREATE TABLE b (id INTEGER);",
+				`CREATE TABLE IF NOT EXISTS "versions" (id BIGSERIAL PRIMARY KEY, version INTEGER NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+				`INSERT INTO "versions" (version, applied_at) VALUES ($1, now())`,
+				"COMMIT",
+			},
+		},
+		{
+			name:   "up to date",
+			exists: true,
+			v:      2,
+			want:   []string{"BEGIN", "SELECT pg_advisory_xact_lock($1)", "COMMIT"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, db := newFakeDB(t, 0)
+			f.results["SELECT to_regclass($1) IS NOT NULL"] = [][]driver.Value{{test.exists}}
+			f.results[latest] = [][]driver.Value{{test.v}}
+
+			s := NewSchemaWithStore("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic, PostgresStore{Table: "versions"})
+			if err := s.Migrate(context.Background(), db); err != nil {
+				t.Fatal("cannot migrate:", err)
+			}
+
+			if got := f.statements(); !slices.Equal(got, test.want) {
+				t.Errorf("executed %q, want %q", got, test.want)
+			}
+		})
+	}
+}
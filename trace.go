@@ -0,0 +1,25 @@
+package lazymigrate
+
+import "context"
+
+// Tracer is the interface used by [Schema] to trace each version as it is
+// applied. It is small enough to be implemented on top of OpenTelemetry or any
+// other tracing library without this package depending on it.
+type Tracer interface {
+	// StartSpan starts a span with the given name for the version at the
+	// given index, which should be recorded as an attribute of the span. The
+	// returned context is used for applying the version.
+	StartSpan(ctx context.Context, name string, index int) (context.Context, TraceSpan)
+}
+
+// TraceSpan is a span started by a [Tracer].
+type TraceSpan interface {
+	// RecordError records that applying the version failed with err. It is
+	// called at most once, just before End.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// versionSpanName is the name of the span of each applied version.
+const versionSpanName = "lazymigrate.version"
@@ -0,0 +1,61 @@
+package lazymigrate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// spanKey is the context key that testTracer sets to the index of the span.
+type spanKey struct{}
+
+// testTracer is a [Tracer] that records the spans it starts as strings.
+type testTracer struct {
+	events []string
+}
+
+func (tr *testTracer) StartSpan(ctx context.Context, name string, index int) (context.Context, TraceSpan) {
+	tr.events = append(tr.events, "start "+name)
+	return context.WithValue(ctx, spanKey{}, index), testSpan{tr}
+}
+
+type testSpan struct{ tr *testTracer }
+
+func (s testSpan) RecordError(err error) { s.tr.events = append(s.tr.events, "error "+err.Error()) }
+func (s testSpan) End()                  { s.tr.events = append(s.tr.events, "end") }
+
+func TestMigrateTracer(t *testing.T) {
+	f, db := newFakeDB(t, 0)
+
+	failure := errors.New("disk is full")
+	f.errs["CREATE TABLE b (id INTEGER);"] = failure
+
+	tracer := &testTracer{}
+	s := NewSchemaWithMagic("CREATE TABLE a (id INTEGER);\n-- migrate\nCREATE TABLE b (id INTEGER);", testMagic)
+	s.Tracer = tracer
+
+	var indices []any
+	s.AfterVersion = func(ctx context.Context, index int, sql string) error {
+		indices = append(indices, ctx.Value(spanKey{}))
+		return nil
+	}
+
+	err := s.Migrate(context.Background(), db)
+	if !errors.Is(err, failure) {
+		t.Fatalf("Migrate() = %v, want %v", err, failure)
+	}
+
+	want := []string{
+		"start lazymigrate.version", "end",
+		"start lazymigrate.version", "error " + err.Error(), "end",
+	}
+	if !slices.Equal(tracer.events, want) {
+		t.Errorf("traced %q, want %q", tracer.events, want)
+	}
+
+	// Hooks run with the context of the span.
+	if want := []any{0}; !slices.Equal(indices, want) {
+		t.Errorf("AfterVersion saw spans %v, want %v", indices, want)
+	}
+}
@@ -0,0 +1,71 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// txn is a transaction on a single connection.
+type txn interface {
+	DBTX
+	Commit() error
+	Rollback() error
+}
+
+var _ txn = (*sql.Tx)(nil)
+
+// begin begins a transaction on conn with the given options.
+//
+// If opts is nil and the version is stored in SQLite, the transaction is
+// started with BEGIN IMMEDIATE, which takes the write lock right away. A plain
+// BEGIN only takes it on the first write, which comes after the version is
+// read, so concurrent migrations could all read the same version and then fail
+// with "database is locked" when they try to write.
+func (s *Schema) begin(ctx context.Context, conn *sql.Conn, opts *sql.TxOptions) (txn, error) {
+	switch s.store.(type) {
+	case PragmaStore, TableStore:
+		if opts != nil {
+			break
+		}
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return nil, fmt.Errorf("cannot begin transaction: %w", err)
+		}
+		return &immediateTx{Conn: conn}, nil
+	}
+
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	return tx, nil
+}
+
+// immediateTx is an SQLite transaction started with BEGIN IMMEDIATE directly
+// on a connection, since database/sql has no portable way to do so.
+type immediateTx struct {
+	*sql.Conn
+	done bool
+}
+
+func (tx *immediateTx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	// COMMIT may fail with SQLITE_BUSY, in which case the transaction is still
+	// open and must be rolled back.
+	if _, err := tx.ExecContext(context.Background(), "COMMIT"); err != nil {
+		return err
+	}
+	tx.done = true
+	return nil
+}
+
+func (tx *immediateTx) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	_, err := tx.ExecContext(context.Background(), "ROLLBACK")
+	return err
+}
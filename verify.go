@@ -0,0 +1,92 @@
+package lazymigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ValidateDriverName is the name of the database/sql driver that
+// [Schema.ValidateSQL] opens an in-memory SQLite database with. The driver
+// must be registered by the caller, such as by importing
+// github.com/mattn/go-sqlite3, and must accept ":memory:" as its data source
+// name. It may be changed, such as to "sqlite" for modernc.org/sqlite.
+var ValidateDriverName = "sqlite3"
+
+// Verify migrates db to the latest version and checks that the version store
+// then reports the latest version. It is meant to be used in tests against a
+// fresh database, such as an in-memory SQLite database, to catch broken SQL in
+// the schema early.
+//
+// Migrating and checking are done on a single connection, so it also works
+// with ":memory:" databases, where each connection has its own database.
+func (s *Schema) Verify(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := s.migrateConn(ctx, conn, s.VersionCount(), nil); err != nil {
+		return fmt.Errorf("cannot migrate: %w", err)
+	}
+
+	v, err := s.getVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if latest := s.VersionCount(); v != latest {
+		return fmt.Errorf("database is at version %d after migrating, expected %d", v, latest)
+	}
+
+	return nil
+}
+
+// VerifySchema is like [Schema.Verify] for the given schema string. It is a
+// convenience function around [NewSchema] and [Schema.Verify].
+func VerifySchema(ctx context.Context, db *sql.DB, schema string) error {
+	return NewSchema(schema).Verify(ctx, db)
+}
+
+// ValidateSQL checks that every version of the schema is valid SQL without
+// needing a database from the caller. It opens an in-memory SQLite database
+// with the driver named by [ValidateDriverName], applies each version in
+// order within a single transaction, and always rolls it back. It is meant as
+// a lint to be run during development, using SQLite itself as the validator.
+//
+// The first version that fails is reported as a [*MigrationError] with its
+// index. Versions are rewritten as by [Schema.Rewrite] and applied without
+// their down sections, but no hooks are called and the version is not
+// recorded.
+func (s *Schema) ValidateSQL(ctx context.Context) error {
+	db, err := sql.Open(ValidateDriverName, ":memory:")
+	if err != nil {
+		return fmt.Errorf("cannot open in-memory database: %w", err)
+	}
+	defer db.Close()
+
+	// Every connection to ":memory:" has its own database.
+	db.SetMaxOpenConns(1)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	return s.EachVersion(func(i int, version string) error {
+		rewritten, err := s.rewrite(i, version)
+		if err != nil {
+			return err
+		}
+
+		up, _, _ := splitDown(rewritten)
+		if strings.TrimSpace(up) == "" {
+			return fmt.Errorf("cannot apply migration %d (from 0th): %w", i, ErrEmptyVersion)
+		}
+
+		return s.execStatements(ctx, tx, i, up, 0)
+	})
+}